@@ -1,22 +1,36 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
+	"flag"
 	"fmt"
+	"github.com/t3nna/http-from-tcp/internal/accesslog"
 	"github.com/t3nna/http-from-tcp/internal/headers"
 	"github.com/t3nna/http-from-tcp/internal/request"
 	"github.com/t3nna/http-from-tcp/internal/response"
 	"github.com/t3nna/http-from-tcp/internal/server"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 )
 
 const port = 42069
 
+// shutdownTimeout bounds how long in-flight requests may take to finish
+// after a stop signal.
+const shutdownTimeout = 10 * time.Second
+
+// Access log files are rotated at accessLogMaxSize, keeping
+// accessLogBackups old ones.
+const (
+	accessLogMaxSize = 10 << 20
+	accessLogBackups = 5
+)
+
 func respond400() []byte {
 	return []byte(`
 <html>
@@ -66,94 +80,127 @@ func toStr(bytes []byte) string {
 	return out
 }
 
-func main() {
-	s, err := server.Serve(port, func(w *response.Writer, req *request.Request) {
-		h := response.GetDefaultHeaders(0)
-		h.Replace("content-type", "text/html")
-		body := respond200()
-
-		if req.RequestLine.RequestTarget == "/yourproblem" {
-			w.WriteStatusLine(response.StatusBarRequest)
-
-			body = respond400()
-			h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
-			w.WriteHeaders(h)
-			w.WriteBody(body)
-		} else if req.RequestLine.RequestTarget == "/myproblem" {
-			w.WriteStatusLine(response.StatusInternalServerError)
-
-			body = respond500()
-			h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
-			w.WriteHeaders(h)
-			w.WriteBody(body)
-
-		} else if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/stream") {
-			target := req.RequestLine.RequestTarget
-			res, err := http.Get("https://httpbin.org/" + target[len("/httpbin/"):])
-			if err != nil {
-				body = respond500()
-			} else {
-				w.WriteStatusLine(response.StatusOK)
-				h.Set("transfer-encoding", "chunked")
-				h.Delete("content-length")
-				h.Replace("content-type", "text/plain")
-				h.Set("Trailer", "X-Content-SHA256")
-				h.Set("Trailer", "X-Content-Length")
-				w.WriteHeaders(h)
-
-				var fullBody []byte
-				for {
-					data := make([]byte, 32)
-					n, err := res.Body.Read(data)
-					if err != nil {
-						break
-					}
-
-					fullBody = append(fullBody, data[:n]...)
-					w.WriteBody([]byte(fmt.Sprintf("%x\r\n", n)))
-					w.WriteBody(data[:n])
-					w.WriteBody([]byte("\r\n"))
-				}
-				w.WriteBody([]byte("0\r\n"))
-				trailer := headers.NewHeaders()
-				check := sha256.Sum256(fullBody)
-				trailer.Set("X-Content-SHA256", toStr(check[:]))
-				trailer.Set("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
-				w.WriteHeaders(trailer)
-
-				return
-			}
-
-		} else if req.RequestLine.RequestTarget == "/video" {
-
-			f, err := os.ReadFile("assets/vim.mp4")
-			if err != nil {
-				log.Print("error in reading: %v", err)
-				body = respond500()
-			}
-			h.Replace("content-type", "video/mp4")
-			h.Replace("Content-Length", fmt.Sprintf("%d", len(f)))
-			w.WriteStatusLine(response.StatusOK)
-			w.WriteHeaders(h)
-			w.WriteBody(f)
-			return
+func writeHTML(w *response.Writer, statusCode response.StatusCode, body []byte) {
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Content-Type", "text/html")
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+func handleYourProblem(w *response.Writer, req *request.Request) {
+	writeHTML(w, response.StatusBadRequest, respond400())
+}
 
+func handleMyProblem(w *response.Writer, req *request.Request) {
+	writeHTML(w, response.StatusInternalServerError, respond500())
+}
+
+func handleRoot(w *response.Writer, req *request.Request) {
+	writeHTML(w, response.StatusOK, respond200())
+}
+
+func handleHttpbinStream(w *response.Writer, req *request.Request) {
+	// Tie the upstream call to the request so it stops when the client
+	// disconnects or the server shuts down.
+	upstream, err := http.NewRequestWithContext(req.Context(), "GET", "https://httpbin.org/stream/"+req.PathValue("n"), nil)
+	if err != nil {
+		writeHTML(w, response.StatusInternalServerError, respond500())
+		return
+	}
+	res, err := http.DefaultClient.Do(upstream)
+	if err != nil {
+		writeHTML(w, response.StatusInternalServerError, respond500())
+		return
+	}
+	defer res.Body.Close()
+
+	h := response.GetDefaultHeaders(0)
+	h.Set("Transfer-Encoding", "chunked")
+	h.Delete("Content-Length")
+	h.Add("Trailer", "X-Content-SHA256")
+	h.Add("Trailer", "X-Content-Length")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+
+	var fullBody []byte
+	for {
+		data := make([]byte, 32)
+		n, err := res.Body.Read(data)
+		fullBody = append(fullBody, data[:n]...)
+		w.WriteChunkedBody(data[:n])
+		if err != nil {
+			break
 		}
+	}
+	w.WriteChunkedBodyDone()
+	trailer := headers.NewHeaders()
+	check := sha256.Sum256(fullBody)
+	trailer.Set("X-Content-SHA256", toStr(check[:]))
+	trailer.Set("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
+	w.WriteTrailers(trailer)
+}
 
-		w.WriteStatusLine(response.StatusOK)
+func main() {
+	router := server.NewRouter()
+	router.Handle("GET", "/", handleRoot)
+	router.Handle("GET", "/yourproblem", handleYourProblem)
+	router.Handle("GET", "/myproblem", handleMyProblem)
+	router.Handle("GET", "/httpbin/stream/{n}", handleHttpbinStream)
+
+	debug := flag.Bool("debug", false, "log at debug level, including raw request heads")
+	accessLog := flag.String("access-log", "", "write a Combined Log Format access log to this file")
+	flag.Parse()
+
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	slog.SetDefault(logger)
+
+	if assets, err := server.DirFS("assets"); err != nil {
+		logger.Warn("not serving /video", "error", err)
+	} else {
+		router.Handle("GET", "/video", func(w *response.Writer, req *request.Request) {
+			server.ServeFile(w, req, assets, "vim.mp4")
+		})
+	}
 
-		h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
-		w.WriteHeaders(h)
-		w.WriteBody(body)
-	})
+	middleware := []server.Middleware{
+		server.Recover(logger),
+		server.Logging(logger),
+	}
+	if *accessLog != "" {
+		f, err := accesslog.OpenRotatingFile(*accessLog, accessLogMaxSize, accessLogBackups)
+		if err != nil {
+			logger.Error("opening access log", "error", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		middleware = append(middleware, accesslog.Middleware(f, accesslog.Combined))
+	}
+	middleware = append(middleware, server.RequestID(), server.Timing())
+	handler := server.Chain(router.Handler(), middleware...)
+
+	config := server.DefaultConfig()
+	config.Logger = logger
+	s, err := server.ServeConfig(port, config, handler)
 	if err != nil {
-		log.Fatalf("Error starting s: %v", err)
+		logger.Error("starting server", "error", err)
+		os.Exit(1)
 	}
-	defer s.Close()
-	log.Println("Server started on port", port)
+	logger.Info("server started", "port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
-	log.Println("Server gracefully stopped")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		logger.Warn("server forced to stop", "error", err)
+		return
+	}
+	logger.Info("server gracefully stopped")
 }
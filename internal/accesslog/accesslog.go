@@ -0,0 +1,150 @@
+// Package accesslog writes one line per request in the formats log tooling
+// already understands: Apache's Common and Combined Log Formats, or JSON.
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+	"github.com/t3nna/http-from-tcp/internal/server"
+)
+
+type Format int
+
+const (
+	// Common is the Common Log Format:
+	//	host ident authuser [date] "request" status bytes
+	Common Format = iota
+	// Combined is Common followed by the quoted Referer and User-Agent.
+	Combined
+	// JSON writes one JSON object per line.
+	JSON
+)
+
+// clfTime is the timestamp layout of the Common Log Format.
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// now is replaced in tests.
+var now = time.Now
+
+// entry is what gets logged about one request.
+type entry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Target     string    `json:"target"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs float64   `json:"duration_ms"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+// Middleware logs every request to out in format once the handler is done.
+// The status and size are what the response.Writer actually sent, including
+// an implicit 200, or the 500 a panicking handler will be answered with.
+// Lines are written whole, one Write each, so out may be shared between
+// servers.
+func Middleware(out io.Writer, format Format) server.Middleware {
+	var mu sync.Mutex
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := now()
+			defer func() {
+				rec := recover()
+				status := server.FinishedStatus(w, rec)
+				line := formatEntry(newEntry(req, w, status, start), format)
+				mu.Lock()
+				out.Write(line)
+				mu.Unlock()
+				if rec != nil {
+					panic(rec)
+				}
+			}()
+			next(w, req)
+		}
+	}
+}
+
+func newEntry(req *request.Request, w *response.Writer, status response.StatusCode, start time.Time) entry {
+	e := entry{
+		Time:       start,
+		RemoteAddr: req.RemoteAddr,
+		Method:     req.RequestLine.Method,
+		Target:     req.RequestLine.RequestTarget,
+		Proto:      "HTTP/" + req.RequestLine.HttpVersion,
+		Status:     int(status),
+		Bytes:      w.BytesWritten(),
+		DurationMs: float64(now().Sub(start).Microseconds()) / 1000,
+	}
+	e.Referer, _ = req.Headers.Get("referer")
+	e.UserAgent, _ = req.Headers.Get("user-agent")
+	return e
+}
+
+func formatEntry(e entry, format Format) []byte {
+	if format == JSON {
+		line, _ := json.Marshal(e)
+		return append(line, '\n')
+	}
+
+	host := e.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+
+	line := fmt.Appendf(nil, "%s - - [%s] \"%s %s %s\" %d %s",
+		orDash(host),
+		e.Time.Format(clfTime),
+		escape(e.Method),
+		escape(e.Target),
+		escape(e.Proto),
+		e.Status,
+		size,
+	)
+	if format == Combined {
+		line = fmt.Appendf(line, " \"%s\" \"%s\"", escape(orDash(e.Referer)), escape(orDash(e.UserAgent)))
+	}
+	return append(line, '\n')
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escape makes client-supplied text safe to put between double quotes,
+// the way Apache does: quotes and backslashes are backslash-escaped and
+// control or non-ASCII bytes written as \xHH, so a client can't forge log
+// lines.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,138 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+	"github.com/t3nna/http-from-tcp/internal/server"
+)
+
+func fixedClock(t *testing.T) {
+	start := time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60))
+	calls := 0
+	now = func() time.Time {
+		calls++
+		return start.Add(time.Duration(calls-1) * 1500 * time.Microsecond)
+	}
+	t.Cleanup(func() { now = time.Now })
+}
+
+func logRequest(t *testing.T, format Format, raw string, h server.Handler) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "127.0.0.1:51234"
+
+	var out, log bytes.Buffer
+	Middleware(&log, format)(h)(response.NewWriter(&out), req)
+	return log.String()
+}
+
+func writeBody(body string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestFormats(t *testing.T) {
+	const raw = "GET /apache_pb.gif HTTP/1.1\r\nHost: localhost\r\n" +
+		"Referer: http://www.example.com/start.html\r\nUser-Agent: Mozilla/4.08 [en] (Win98; I ;Nav)\r\n\r\n"
+
+	testCases := []struct {
+		name     string
+		format   Format
+		raw      string
+		handler  server.Handler
+		expected string
+	}{
+		{
+			name:     "Common",
+			format:   Common,
+			raw:      raw,
+			handler:  writeBody(strings.Repeat("x", 2326)),
+			expected: "127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET /apache_pb.gif HTTP/1.1\" 200 2326\n",
+		},
+		{
+			name:    "Combined",
+			format:  Combined,
+			raw:     raw,
+			handler: writeBody(strings.Repeat("x", 2326)),
+			expected: "127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET /apache_pb.gif HTTP/1.1\" 200 2326" +
+				" \"http://www.example.com/start.html\" \"Mozilla/4.08 [en] (Win98; I ;Nav)\"\n",
+		},
+		{
+			name:     "Implicit empty response",
+			format:   Combined,
+			raw:      "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			handler:  func(w *response.Writer, req *request.Request) {},
+			expected: "127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET / HTTP/1.1\" 200 - \"-\" \"-\"\n",
+		},
+		{
+			name:     "Quotes are escaped",
+			format:   Combined,
+			raw:      "GET / HTTP/1.1\r\nHost: localhost\r\nUser-Agent: evil\" 200 0 \"\\\r\n\r\n",
+			handler:  writeBody("ok"),
+			expected: "127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET / HTTP/1.1\" 200 2 \"-\" \"evil\\\" 200 0 \\\"\\\\\"\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fixedClock(t)
+			assert.Equal(t, tc.expected, logRequest(t, tc.format, tc.raw, tc.handler))
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	fixedClock(t)
+	line := logRequest(t, JSON,
+		"POST /upload HTTP/1.1\r\nHost: localhost\r\nUser-Agent: curl/8.5.0\r\nContent-Length: 2\r\n\r\nhi",
+		func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusCreated)
+			w.WriteHeaders(response.GetDefaultHeaders(7))
+			w.WriteBody([]byte("created"))
+		})
+	require.True(t, strings.HasSuffix(line, "}\n"))
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal([]byte(line), &got))
+	assert.Equal(t, map[string]any{
+		"time":        "2000-10-10T13:55:36-07:00",
+		"remote_addr": "127.0.0.1:51234",
+		"method":      "POST",
+		"target":      "/upload",
+		"proto":       "HTTP/1.1",
+		"status":      float64(201),
+		"bytes":       float64(7),
+		"duration_ms": 1.5,
+		"user_agent":  "curl/8.5.0",
+	}, got)
+}
+
+func TestPanicIsLogged(t *testing.T) {
+	fixedClock(t)
+	req, err := request.RequestFromReader(strings.NewReader("GET /boom HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = "127.0.0.1:51234"
+
+	var out, log bytes.Buffer
+	recoverLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := server.Chain(func(w *response.Writer, req *request.Request) { panic("boom") },
+		server.Recover(recoverLogger), Middleware(&log, Common))
+	handler(response.NewWriter(&out), req)
+
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 500 Internal Server Error\r\n"), out.String())
+	assert.Equal(t, "127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET /boom HTTP/1.1\" 500 -\n", log.String())
+}
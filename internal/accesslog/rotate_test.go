@@ -0,0 +1,71 @@
+package accesslog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	assert.Equal(t, "six\n", readFile(t, path))
+	assert.Equal(t, "four\nfive\n", readFile(t, path+".1"))
+	assert.Equal(t, "three\n", readFile(t, path+".2"))
+	assert.NoFileExists(t, path+".3")
+
+	// Test: Reopening continues with the existing size
+	f, err = OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("seven\n"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("eight\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, "eight\n", readFile(t, path))
+	assert.Equal(t, "six\nseven\n", readFile(t, path+".1"))
+
+	_, err = f.Write([]byte("closed"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestRotatingFileRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 10, 1)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// A non-empty directory where the backup goes makes the rename fail.
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "blocker"), 0o755))
+
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "one\ntwo\nthree\n", readFile(t, path))
+
+	// Test: Rotation resumes once the obstacle is gone
+	require.NoError(t, os.RemoveAll(path+".1"))
+	_, err = f.Write([]byte("four\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "four\n", readFile(t, path))
+	assert.Equal(t, "one\ntwo\nthree\n", readFile(t, path+".1"))
+}
@@ -33,69 +33,203 @@ func isToken(s []byte) bool {
 	return true
 }
 
+// Headers is an ordered list of header fields. Names keep the case they
+// were added or parsed with and are matched case-insensitively; a name may
+// occur several times, each occurrence being its own field line on the wire.
 type Headers struct {
-	headers map[string]string
+	fields []field
+}
+
+type field struct {
+	name  string
+	value string
 }
 
 var rn = []byte("\r\n")
 
 func NewHeaders() *Headers {
-	return &Headers{
-		headers: map[string]string{},
-	}
+	return &Headers{}
 }
 
+// Get returns the values of name joined with ",", which is equivalent for
+// list-valued fields. Set-Cookie is the exception and must be read with
+// Values.
 func (h *Headers) Get(name string) (string, bool) {
-
-	str, ok := h.headers[strings.ToLower(name)]
-	return str, ok
+	value, found := "", false
+	for _, f := range h.fields {
+		if !strings.EqualFold(f.name, name) {
+			continue
+		}
+		if found {
+			value += "," + f.value
+		} else {
+			value, found = f.value, true
+		}
+	}
+	return value, found
 }
 
-func (h *Headers) Set(name string, value string) {
-	v, ok := h.Get(name)
-	name = strings.ToLower(name)
+// Values returns every value of name in order, one per field line.
+func (h *Headers) Values(name string) []string {
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, name) {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
 
-	if !ok {
-		h.headers[name] = value
-		return
-	} else {
+// Add appends a field, keeping any existing ones with the same name.
+func (h *Headers) Add(name string, value string) {
+	if h.fields == nil {
+		// Enough for a typical request in one allocation.
+		h.fields = make([]field, 0, 16)
+	}
+	h.fields = append(h.fields, field{name: name, value: value})
+}
 
-		h.headers[name] = fmt.Sprintf("%s,%s", v, value)
+// Set replaces all fields named name with a single one. It takes the place
+// of the first existing field, or is appended if there was none.
+func (h *Headers) Set(name string, value string) {
+	for i, f := range h.fields {
+		if strings.EqualFold(f.name, name) {
+			h.fields[i] = field{name: name, value: value}
+			h.deleteFrom(i+1, name)
+			return
+		}
 	}
+	h.Add(name, value)
 }
-func (h *Headers) Replace(name string, value string) {
-	name = strings.ToLower(name)
-	h.headers[name] = value
 
+// Replace is Set.
+//
+// Deprecated: use Set.
+func (h *Headers) Replace(name string, value string) {
+	h.Set(name, value)
 }
 
 func (h *Headers) Delete(name string) {
-	name = strings.ToLower(name)
-	delete(h.headers, name)
+	h.deleteFrom(0, name)
+}
+
+// deleteFrom removes the fields named name at index i or later.
+func (h *Headers) deleteFrom(i int, name string) {
+	kept := h.fields[:i]
+	for _, f := range h.fields[i:] {
+		if !strings.EqualFold(f.name, name) {
+			kept = append(kept, f)
+		}
+	}
+	clear(h.fields[len(kept):])
+	h.fields = kept
+}
 
+// Len returns the number of field lines.
+func (h *Headers) Len() int {
+	return len(h.fields)
 }
 
+// HasToken reports whether the comma-separated lists stored under name
+// contain token, compared case-insensitively (e.g. "Connection: keep-alive, close").
+func (h *Headers) HasToken(name string, token string) bool {
+	for _, f := range h.fields {
+		if !strings.EqualFold(f.name, name) {
+			continue
+		}
+		for _, part := range strings.Split(f.value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ForEach calls cb for every field line in order, with the name in its
+// original case.
 func (h *Headers) ForEach(cb func(n, v string)) {
-	for k, v := range h.headers {
-		cb(k, v)
+	for _, f := range h.fields {
+		cb(f.name, f.value)
 	}
 }
 
-func parseHeader(fieldLine []byte) (string, string, error) {
-	parts := bytes.SplitN(fieldLine, []byte(":"), 2)
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("malformed header")
+// commonNames interns the names of frequent fields, in canonical and lower
+// case, so parsing them doesn't allocate a new string per request.
+var commonNames = map[string]string{}
+
+func init() {
+	for _, name := range []string{
+		"Accept", "Accept-Encoding", "Accept-Language", "Authorization",
+		"Cache-Control", "Connection", "Content-Length", "Content-Type",
+		"Cookie", "Date", "Expect", "Host", "If-Modified-Since",
+		"If-None-Match", "If-Range", "Origin", "Range", "Referer", "TE",
+		"Trailer", "Transfer-Encoding", "Upgrade", "User-Agent",
+		"X-Forwarded-For", "X-Request-ID",
+	} {
+		commonNames[name] = name
+		lower := strings.ToLower(name)
+		commonNames[lower] = lower
 	}
-	name := parts[0]
-	value := bytes.TrimSpace(parts[1])
-	if !bytes.Equal(name, bytes.TrimSpace(name)) {
-		return "", "", fmt.Errorf("malformed key in header")
+}
+
+func internName(name []byte) string {
+	if interned, ok := commonNames[string(name)]; ok {
+		return interned
 	}
+	return string(name)
+}
 
-	return string(name), string(value), nil
+var ERROR_MALFORMED_HEADER = fmt.Errorf("malformed header: missing colon")
+var ERROR_MALFORMED_HEADER_NAME = fmt.Errorf("malformed header name")
+var ERROR_SPACE_BEFORE_COLON = fmt.Errorf("whitespace between header name and colon")
+var ERROR_OBS_FOLD = fmt.Errorf("obsolete line folding in header")
+var ERROR_INVALID_HEADER_VALUE = fmt.Errorf("invalid character in header value")
 
+// parseHeader splits a field line into name and value, with the optional
+// whitespace around the value trimmed. It does not validate the name.
+func parseHeader(fieldLine []byte) ([]byte, []byte, error) {
+	if isWhitespace(fieldLine[0]) {
+		// A line starting with whitespace continues the previous one
+		// (obs-fold), or precedes the first field; both must be rejected.
+		return nil, nil, ERROR_OBS_FOLD
+	}
+	idx := bytes.IndexByte(fieldLine, ':')
+	if idx == -1 {
+		return nil, nil, ERROR_MALFORMED_HEADER
+	}
+	name := fieldLine[:idx]
+	if len(name) > 0 && isWhitespace(name[len(name)-1]) {
+		return nil, nil, ERROR_SPACE_BEFORE_COLON
+	}
+	value := bytes.Trim(fieldLine[idx+1:], " \t")
+	if !validValue(value) {
+		return nil, nil, ERROR_INVALID_HEADER_VALUE
+	}
+
+	return name, value, nil
+
+}
+
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// validValue reports whether value matches the field-value grammar of RFC
+// 9110: visible ASCII, obs-text (0x80-0xFF), and spaces or tabs between
+// them. Any other control character, including a bare CR or LF, is invalid.
+func validValue(value []byte) bool {
+	for _, c := range value {
+		if c < 0x20 && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
 }
 
+// Parse reads complete field lines from data until the empty line ending the
+// section. It returns how many bytes it consumed and whether the section is
+// done; a partial line is left for the next call.
 func (h *Headers) Parse(data []byte) (int, bool, error) {
 
 	read := 0
@@ -120,13 +254,13 @@ func (h *Headers) Parse(data []byte) (int, bool, error) {
 			return 0, false, err
 		}
 
-		if !isToken([]byte(name)) {
-			return 0, false, fmt.Errorf("malformed header name")
+		if !isToken(name) {
+			return 0, false, ERROR_MALFORMED_HEADER_NAME
 		}
 
 		read += idx + len(rn)
 
-		h.Set(name, value)
+		h.Add(internName(name), string(value))
 
 	}
 
@@ -47,3 +47,77 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestHeadersMultiValue(t *testing.T) {
+	h := NewHeaders()
+	data := []byte("Host: localhost\r\nSet-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nX-Forwarded-For: 10.0.0.1\r\nset-cookie: b=2\r\n\r\n")
+	_, done, err := h.Parse(data)
+	require.NoError(t, err)
+	require.True(t, done)
+
+	// Test: Values keeps each field line apart, including commas inside one
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, h.Values("SET-COOKIE"))
+	assert.Nil(t, h.Values("missing"))
+
+	// Test: Add appends, Set replaces in place of the first occurrence
+	h.Add("X-Forwarded-For", "10.0.0.2")
+	v, _ := h.Get("x-forwarded-for")
+	assert.Equal(t, "10.0.0.1,10.0.0.2", v)
+	h.Set("set-cookie", "c=3")
+	assert.Equal(t, []string{"c=3"}, h.Values("Set-Cookie"))
+
+	// Test: Original case and order are kept
+	var lines []string
+	h.ForEach(func(n, v string) {
+		lines = append(lines, n+": "+v)
+	})
+	assert.Equal(t, []string{
+		"Host: localhost",
+		"set-cookie: c=3",
+		"X-Forwarded-For: 10.0.0.1",
+		"X-Forwarded-For: 10.0.0.2",
+	}, lines)
+
+	h.Delete("x-forwarded-for")
+	assert.Equal(t, 2, h.Len())
+	_, ok := h.Get("X-Forwarded-For")
+	assert.False(t, ok)
+}
+
+func TestHeadersParseErrors(t *testing.T) {
+	testCases := []struct {
+		name        string
+		data        string
+		expectedErr error
+	}{
+		{name: "Missing colon", data: "Host localhost\r\n\r\n", expectedErr: ERROR_MALFORMED_HEADER},
+		{name: "Space before colon", data: "Host : localhost\r\n\r\n", expectedErr: ERROR_SPACE_BEFORE_COLON},
+		{name: "Tab before colon", data: "Host\t: localhost\r\n\r\n", expectedErr: ERROR_SPACE_BEFORE_COLON},
+		{name: "Obs-fold", data: "X-Long: first\r\n  second\r\n\r\n", expectedErr: ERROR_OBS_FOLD},
+		{name: "Leading whitespace", data: " Host: localhost\r\n\r\n", expectedErr: ERROR_OBS_FOLD},
+		{name: "Invalid name", data: "H@st: localhost\r\n\r\n", expectedErr: ERROR_MALFORMED_HEADER_NAME},
+		{name: "Empty name", data: ": localhost\r\n\r\n", expectedErr: ERROR_MALFORMED_HEADER_NAME},
+		{name: "NUL in value", data: "X-Bad: a\x00b\r\n\r\n", expectedErr: ERROR_INVALID_HEADER_VALUE},
+		{name: "Bare CR in value", data: "X-Bad: a\rb\r\n\r\n", expectedErr: ERROR_INVALID_HEADER_VALUE},
+		{name: "Bare LF in value", data: "X-Bad: a\nb\r\n\r\n", expectedErr: ERROR_INVALID_HEADER_VALUE},
+		{name: "DEL in value", data: "X-Bad: a\x7fb\r\n\r\n", expectedErr: ERROR_INVALID_HEADER_VALUE},
+		{name: "Tabs and obs-text are valid", data: "X-Ok: a\tb \xe9\r\n\r\n"},
+		{name: "Empty value is valid", data: "X-Empty:\r\n\r\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHeaders()
+			n, done, err := h.Parse([]byte(tc.data))
+			if tc.expectedErr == nil {
+				require.NoError(t, err)
+				assert.True(t, done)
+				assert.Equal(t, len(tc.data), n)
+				return
+			}
+			require.ErrorIs(t, err, tc.expectedErr)
+			assert.Equal(t, 0, n)
+			assert.False(t, done)
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package headers
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ERROR_INVALID_CONTENT_LENGTH = fmt.Errorf("invalid content-length")
+var ERROR_INVALID_RANGE = fmt.Errorf("invalid range")
+
+// TimeFormat is the IMF-fixdate layout used for HTTP dates, e.g. in Date
+// and Last-Modified. Times must be in UTC when formatted with it.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// dateFormats are the HTTP-date forms recipients must accept: IMF-fixdate
+// and the obsolete RFC 850 and asctime forms.
+var dateFormats = []string{
+	TimeFormat,
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// ParseTime parses an HTTP-date in any of the forms RFC 9110 allows.
+func ParseTime(value string) (time.Time, error) {
+	var err error
+	for _, layout := range dateFormats {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// getTime returns the HTTP-date in name. A missing or unparsable date is
+// reported as absent, since RFC 9110 has invalid conditional dates ignored.
+func (h *Headers) getTime(name string) (time.Time, bool) {
+	value, ok := h.Get(name)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := ParseTime(strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// ContentLength returns the Content-Length, or -1 if there is none. Values
+// that aren't a single non-negative decimal number are an error; repeated
+// identical values are accepted.
+func (h *Headers) ContentLength() (int64, error) {
+	values := h.Values("content-length")
+	if len(values) == 0 {
+		return -1, nil
+	}
+
+	length := int64(-1)
+	for _, v := range strings.Split(strings.Join(values, ","), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" || strings.TrimLeft(v, "0123456789") != "" {
+			return -1, ERROR_INVALID_CONTENT_LENGTH
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || (length != -1 && n != length) {
+			return -1, ERROR_INVALID_CONTENT_LENGTH
+		}
+		length = n
+	}
+	return length, nil
+}
+
+// ContentType returns the lowercased media type of Content-Type and its
+// parameters, e.g. "text/html" and {"charset": "utf-8"}. It returns "" and
+// no error if there is no Content-Type.
+func (h *Headers) ContentType() (string, map[string]string, error) {
+	value, ok := h.Get("content-type")
+	if !ok {
+		return "", nil, nil
+	}
+	return mime.ParseMediaType(value)
+}
+
+// MediaRange is one element of an Accept header.
+type MediaRange struct {
+	// Type is the lowercased range, such as "text/html", "text/*" or "*/*".
+	Type   string
+	Params map[string]string
+	// Q is the weight, 1 unless the client gave another.
+	Q float64
+}
+
+// Specificity ranks how closely the range matches mediaType: 2 for an exact
+// match, 1 for a subtype wildcard, 0 for "*/*" and -1 for no match.
+func (m MediaRange) Specificity(mediaType string) int {
+	switch {
+	case m.Type == strings.ToLower(mediaType):
+		return 2
+	case strings.HasSuffix(m.Type, "/*") && strings.HasPrefix(strings.ToLower(mediaType), strings.TrimSuffix(m.Type, "*")):
+		return 1
+	case m.Type == "*/*":
+		return 0
+	}
+	return -1
+}
+
+// Accept returns the media ranges of the Accept header in the order they
+// were sent. Elements without a type are skipped, and an invalid weight
+// counts as 0.
+func (h *Headers) Accept() []MediaRange {
+	var ranges []MediaRange
+	for _, line := range h.Values("accept") {
+		for _, part := range strings.Split(line, ",") {
+			params := strings.Split(part, ";")
+			m := MediaRange{Type: strings.ToLower(strings.TrimSpace(params[0])), Q: 1}
+			if m.Type == "" {
+				continue
+			}
+			for _, p := range params[1:] {
+				name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+				name = strings.ToLower(strings.TrimSpace(name))
+				value = strings.Trim(strings.TrimSpace(value), `"`)
+				if name == "q" {
+					q, err := strconv.ParseFloat(value, 64)
+					if err != nil || q < 0 || q > 1 {
+						q = 0
+					}
+					m.Q = q
+					continue
+				}
+				if m.Params == nil {
+					m.Params = map[string]string{}
+				}
+				m.Params[name] = value
+			}
+			ranges = append(ranges, m)
+		}
+	}
+	return ranges
+}
+
+// Authorization splits the Authorization header into its scheme, such as
+// "Basic" or "Bearer", and the credentials that follow it.
+func (h *Headers) Authorization() (scheme string, credentials string, ok bool) {
+	value, ok := h.Get("authorization")
+	if !ok {
+		return "", "", false
+	}
+	scheme, credentials, _ = strings.Cut(strings.TrimSpace(value), " ")
+	if scheme == "" {
+		return "", "", false
+	}
+	return scheme, strings.TrimSpace(credentials), true
+}
+
+// Cookie is a name-value pair from a Cookie header.
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// Cookies returns the cookies of every Cookie header in order. Pairs
+// without a name are skipped; quotes around a value are removed.
+func (h *Headers) Cookies() []Cookie {
+	var cookies []Cookie
+	for _, line := range h.Values("cookie") {
+		for _, pair := range strings.Split(line, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if name == "" {
+				continue
+			}
+			if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1 : len(value)-1]
+			}
+			cookies = append(cookies, Cookie{Name: name, Value: value})
+		}
+	}
+	return cookies
+}
+
+// ByteRange is one range of a "Range: bytes=..." header. End is inclusive.
+// A suffix range ("-500", the last 500 bytes) has Start -1 and the suffix
+// length in End; an open range ("9500-") has End -1.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Range parses a bytes Range header. It returns nil and no error if there
+// is none, and ERROR_INVALID_RANGE for other units or malformed ranges.
+func (h *Headers) Range() ([]ByteRange, error) {
+	value, ok := h.Get("range")
+	if !ok {
+		return nil, nil
+	}
+	unit, set, ok := strings.Cut(strings.TrimSpace(value), "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, ERROR_INVALID_RANGE
+	}
+
+	var ranges []ByteRange
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			// Empty list elements are allowed.
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, ERROR_INVALID_RANGE
+		}
+		r := ByteRange{Start: -1, End: -1}
+		var err error
+		if first != "" {
+			if r.Start, err = parseRangeInt(first); err != nil {
+				return nil, err
+			}
+		}
+		if last != "" {
+			if r.End, err = parseRangeInt(last); err != nil {
+				return nil, err
+			}
+		}
+		if (r.Start == -1 && r.End == -1) || (r.Start != -1 && r.End != -1 && r.End < r.Start) {
+			return nil, ERROR_INVALID_RANGE
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, ERROR_INVALID_RANGE
+	}
+	return ranges, nil
+}
+
+func parseRangeInt(s string) (int64, error) {
+	if strings.TrimLeft(s, "0123456789") != "" {
+		return 0, ERROR_INVALID_RANGE
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ERROR_INVALID_RANGE
+	}
+	return n, nil
+}
+
+// IfModifiedSince returns the If-Modified-Since date, if there is a valid
+// one.
+func (h *Headers) IfModifiedSince() (time.Time, bool) {
+	return h.getTime("if-modified-since")
+}
+
+// IfNoneMatch returns the entity tags of If-None-Match, quotes and "W/"
+// prefix included, or wildcard set for "If-None-Match: *".
+func (h *Headers) IfNoneMatch() (etags []string, wildcard bool) {
+	return h.entityTags("if-none-match")
+}
+
+// IfRange parses If-Range, which holds either one entity tag, quotes and
+// "W/" prefix included, or an HTTP-date. ok is false if the header is
+// missing or is neither.
+func (h *Headers) IfRange() (etag string, date time.Time, ok bool) {
+	value, found := h.Get("if-range")
+	if !found {
+		return "", time.Time{}, false
+	}
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, `W/"`) {
+		etags, _ := h.entityTags("if-range")
+		if len(etags) != 1 || etags[0] != value {
+			return "", time.Time{}, false
+		}
+		return value, time.Time{}, true
+	}
+	date, ok = h.getTime("if-range")
+	return "", date, ok
+}
+
+// entityTags parses a list of entity tags, which may contain commas inside
+// their quotes.
+func (h *Headers) entityTags(name string) ([]string, bool) {
+	var etags []string
+	for _, line := range h.Values(name) {
+		rest := strings.TrimSpace(line)
+		if rest == "*" {
+			return nil, true
+		}
+		for rest != "" {
+			rest = strings.TrimLeft(rest, " \t,")
+			if rest == "" {
+				break
+			}
+			start := 0
+			if strings.HasPrefix(rest, "W/") {
+				start = 2
+			}
+			if start >= len(rest) || rest[start] != '"' {
+				// Not an entity tag; skip to the next element.
+				_, rest, _ = strings.Cut(rest, ",")
+				continue
+			}
+			end := strings.IndexByte(rest[start+1:], '"')
+			if end == -1 {
+				break
+			}
+			end += start + 2
+			etags = append(etags, rest[:end])
+			rest = rest[end:]
+		}
+	}
+	return etags, false
+}
+
+// Date returns the Date header, if there is a valid one.
+func (h *Headers) Date() (time.Time, bool) {
+	return h.getTime("date")
+}
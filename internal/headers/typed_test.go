@@ -0,0 +1,287 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func headersWith(fields ...string) *Headers {
+	h := NewHeaders()
+	for i := 0; i+1 < len(fields); i += 2 {
+		h.Add(fields[i], fields[i+1])
+	}
+	return h
+}
+
+func TestContentLength(t *testing.T) {
+	testCases := []struct {
+		name        string
+		headers     *Headers
+		expected    int64
+		expectedErr error
+	}{
+		{name: "Missing", headers: headersWith(), expected: -1},
+		{name: "Valid", headers: headersWith("Content-Length", "42"), expected: 42},
+		{name: "Zero", headers: headersWith("Content-Length", "0"), expected: 0},
+		{name: "Repeated identical", headers: headersWith("Content-Length", "7, 7", "content-length", "7"), expected: 7},
+		{name: "Conflicting", headers: headersWith("Content-Length", "7", "Content-Length", "8"), expected: -1, expectedErr: ERROR_INVALID_CONTENT_LENGTH},
+		{name: "Negative", headers: headersWith("Content-Length", "-1"), expected: -1, expectedErr: ERROR_INVALID_CONTENT_LENGTH},
+		{name: "Plus sign", headers: headersWith("Content-Length", "+5"), expected: -1, expectedErr: ERROR_INVALID_CONTENT_LENGTH},
+		{name: "Garbage", headers: headersWith("Content-Length", "abc"), expected: -1, expectedErr: ERROR_INVALID_CONTENT_LENGTH},
+		{name: "Empty", headers: headersWith("Content-Length", ""), expected: -1, expectedErr: ERROR_INVALID_CONTENT_LENGTH},
+		{name: "Overflow", headers: headersWith("Content-Length", "99999999999999999999"), expected: -1, expectedErr: ERROR_INVALID_CONTENT_LENGTH},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := tc.headers.ContentLength()
+			assert.ErrorIs(t, err, tc.expectedErr)
+			assert.Equal(t, tc.expected, n)
+		})
+	}
+}
+
+func TestContentType(t *testing.T) {
+	testCases := []struct {
+		name           string
+		headers        *Headers
+		expectedType   string
+		expectedParams map[string]string
+		expectErr      bool
+	}{
+		{name: "Missing", headers: headersWith()},
+		{name: "Plain", headers: headersWith("Content-Type", "text/plain"), expectedType: "text/plain", expectedParams: map[string]string{}},
+		{name: "With parameters", headers: headersWith("Content-Type", `Text/HTML; Charset="utf-8"`), expectedType: "text/html", expectedParams: map[string]string{"charset": "utf-8"}},
+		{name: "Malformed", headers: headersWith("Content-Type", "text/html; charset"), expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mediaType, params, err := tc.headers.ContentType()
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedType, mediaType)
+			assert.Equal(t, tc.expectedParams, params)
+		})
+	}
+}
+
+func TestAccept(t *testing.T) {
+	testCases := []struct {
+		name     string
+		headers  *Headers
+		expected []MediaRange
+	}{
+		{name: "Missing", headers: headersWith()},
+		{
+			name:    "Weighted list",
+			headers: headersWith("Accept", "text/html, application/json;q=0.9, */*;q=0.1"),
+			expected: []MediaRange{
+				{Type: "text/html", Q: 1},
+				{Type: "application/json", Q: 0.9},
+				{Type: "*/*", Q: 0.1},
+			},
+		},
+		{
+			name:    "Parameters and several lines",
+			headers: headersWith("Accept", "Text/HTML;level=1", "Accept", "text/*; q=0.5"),
+			expected: []MediaRange{
+				{Type: "text/html", Params: map[string]string{"level": "1"}, Q: 1},
+				{Type: "text/*", Q: 0.5},
+			},
+		},
+		{
+			name:     "Invalid weight and empty elements",
+			headers:  headersWith("Accept", "text/plain;q=abc, , image/png;q=2"),
+			expected: []MediaRange{{Type: "text/plain", Q: 0}, {Type: "image/png", Q: 0}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.headers.Accept())
+		})
+	}
+
+	m := MediaRange{Type: "text/*"}
+	assert.Equal(t, 1, m.Specificity("text/html"))
+	assert.Equal(t, -1, m.Specificity("application/json"))
+	assert.Equal(t, 2, MediaRange{Type: "text/html"}.Specificity("Text/HTML"))
+	assert.Equal(t, 0, MediaRange{Type: "*/*"}.Specificity("image/png"))
+}
+
+func TestAuthorization(t *testing.T) {
+	testCases := []struct {
+		name                string
+		headers             *Headers
+		expectedScheme      string
+		expectedCredentials string
+		expectedOk          bool
+	}{
+		{name: "Missing", headers: headersWith()},
+		{name: "Basic", headers: headersWith("Authorization", "Basic dXNlcjpwYXNz"), expectedScheme: "Basic", expectedCredentials: "dXNlcjpwYXNz", expectedOk: true},
+		{name: "Bearer with extra spaces", headers: headersWith("Authorization", "Bearer   abc.def"), expectedScheme: "Bearer", expectedCredentials: "abc.def", expectedOk: true},
+		{name: "Scheme only", headers: headersWith("Authorization", "Negotiate"), expectedScheme: "Negotiate", expectedOk: true},
+		{name: "Empty", headers: headersWith("Authorization", "")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme, credentials, ok := tc.headers.Authorization()
+			assert.Equal(t, tc.expectedOk, ok)
+			assert.Equal(t, tc.expectedScheme, scheme)
+			assert.Equal(t, tc.expectedCredentials, credentials)
+		})
+	}
+}
+
+func TestCookies(t *testing.T) {
+	testCases := []struct {
+		name     string
+		headers  *Headers
+		expected []Cookie
+	}{
+		{name: "Missing", headers: headersWith()},
+		{
+			name:     "Pairs",
+			headers:  headersWith("Cookie", "session=abc; theme=dark"),
+			expected: []Cookie{{"session", "abc"}, {"theme", "dark"}},
+		},
+		{
+			name:     "Several lines, quotes and empty values",
+			headers:  headersWith("Cookie", `a="quoted"; empty=`, "Cookie", "flag; =nameless; b=2"),
+			expected: []Cookie{{"a", "quoted"}, {"empty", ""}, {"flag", ""}, {"b", "2"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.headers.Cookies())
+		})
+	}
+}
+
+func TestRange(t *testing.T) {
+	testCases := []struct {
+		name        string
+		headers     *Headers
+		expected    []ByteRange
+		expectedErr error
+	}{
+		{name: "Missing", headers: headersWith()},
+		{name: "Single", headers: headersWith("Range", "bytes=0-499"), expected: []ByteRange{{0, 499}}},
+		{name: "Open ended", headers: headersWith("Range", "bytes=9500-"), expected: []ByteRange{{9500, -1}}},
+		{name: "Suffix", headers: headersWith("Range", "bytes=-500"), expected: []ByteRange{{-1, 500}}},
+		{name: "Multiple", headers: headersWith("Range", "Bytes=0-0, -1,,500-600"), expected: []ByteRange{{0, 0}, {-1, 1}, {500, 600}}},
+		{name: "Other unit", headers: headersWith("Range", "items=0-5"), expectedErr: ERROR_INVALID_RANGE},
+		{name: "Last before first", headers: headersWith("Range", "bytes=500-100"), expectedErr: ERROR_INVALID_RANGE},
+		{name: "No numbers", headers: headersWith("Range", "bytes=-"), expectedErr: ERROR_INVALID_RANGE},
+		{name: "Not a number", headers: headersWith("Range", "bytes=a-b"), expectedErr: ERROR_INVALID_RANGE},
+		{name: "Empty set", headers: headersWith("Range", "bytes="), expectedErr: ERROR_INVALID_RANGE},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ranges, err := tc.headers.Range()
+			assert.ErrorIs(t, err, tc.expectedErr)
+			assert.Equal(t, tc.expected, ranges)
+		})
+	}
+}
+
+func TestDates(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		value      string
+		expectedOk bool
+	}{
+		{name: "IMF-fixdate", value: "Sun, 06 Nov 1994 08:49:37 GMT", expectedOk: true},
+		{name: "RFC 850", value: "Sunday, 06-Nov-94 08:49:37 GMT", expectedOk: true},
+		{name: "asctime", value: "Sun Nov  6 08:49:37 1994", expectedOk: true},
+		{name: "Invalid", value: "yesterday"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := headersWith("Date", tc.value, "If-Modified-Since", tc.value)
+			for _, get := range []func() (time.Time, bool){h.Date, h.IfModifiedSince} {
+				got, ok := get()
+				assert.Equal(t, tc.expectedOk, ok)
+				if tc.expectedOk {
+					assert.True(t, want.Equal(got), got)
+				}
+			}
+		})
+	}
+
+	_, ok := headersWith().Date()
+	assert.False(t, ok)
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", want.Format(TimeFormat))
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	testCases := []struct {
+		name             string
+		headers          *Headers
+		expected         []string
+		expectedWildcard bool
+	}{
+		{name: "Missing", headers: headersWith()},
+		{name: "Wildcard", headers: headersWith("If-None-Match", "*"), expectedWildcard: true},
+		{name: "Single", headers: headersWith("If-None-Match", `"xyzzy"`), expected: []string{`"xyzzy"`}},
+		{
+			name:     "List with weak tags and commas inside quotes",
+			headers:  headersWith("If-None-Match", `"a", W/"b,c" ,"d"`, "If-None-Match", `W/"e"`),
+			expected: []string{`"a"`, `W/"b,c"`, `"d"`, `W/"e"`},
+		},
+		{name: "Unquoted elements are skipped", headers: headersWith("If-None-Match", `xyzzy, "ok"`), expected: []string{`"ok"`}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			etags, wildcard := tc.headers.IfNoneMatch()
+			assert.Equal(t, tc.expectedWildcard, wildcard)
+			assert.Equal(t, tc.expected, etags)
+		})
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	testCases := []struct {
+		name         string
+		headers      *Headers
+		expectedETag string
+		expectedDate time.Time
+		expectedOK   bool
+	}{
+		{name: "Missing", headers: headersWith()},
+		{name: "Entity tag", headers: headersWith("If-Range", `"xyzzy"`), expectedETag: `"xyzzy"`, expectedOK: true},
+		{name: "Weak entity tag", headers: headersWith("If-Range", `W/"xyzzy"`), expectedETag: `W/"xyzzy"`, expectedOK: true},
+		{
+			name:         "Date",
+			headers:      headersWith("If-Range", "Sun, 06 Nov 1994 08:49:37 GMT"),
+			expectedDate: time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC),
+			expectedOK:   true,
+		},
+		{name: "Two entity tags", headers: headersWith("If-Range", `"a", "b"`)},
+		{name: "Unterminated entity tag", headers: headersWith("If-Range", `"xyzzy`)},
+		{name: "Neither", headers: headersWith("If-Range", "yesterday")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			etag, date, ok := tc.headers.IfRange()
+			assert.Equal(t, tc.expectedOK, ok)
+			assert.Equal(t, tc.expectedETag, etag)
+			assert.True(t, tc.expectedDate.Equal(date), date)
+		})
+	}
+}
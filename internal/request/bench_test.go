@@ -0,0 +1,118 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+var benchRequests = []struct {
+	name    string
+	request string
+}{
+	{
+		name:    "GET",
+		request: "GET /index.html HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/8.5.0\r\nAccept: */*\r\n\r\n",
+	},
+	{
+		name: "Browser",
+		request: "GET /search?q=tcp&lang=en HTTP/1.1\r\n" +
+			"Host: example.com\r\n" +
+			"User-Agent: Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0\r\n" +
+			"Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\n" +
+			"Accept-Language: en-US,en;q=0.5\r\n" +
+			"Accept-Encoding: gzip, deflate, br\r\n" +
+			"Referer: https://example.com/\r\n" +
+			"Connection: keep-alive\r\n" +
+			"Cookie: session=0123456789abcdef; theme=dark\r\n" +
+			"Upgrade-Insecure-Requests: 1\r\n" +
+			"Sec-Fetch-Dest: document\r\n" +
+			"Sec-Fetch-Mode: navigate\r\n" +
+			"If-None-Match: \"33a64df551425fcc55e4d42a148795d9f25f89d4\"\r\n" +
+			"\r\n",
+	},
+	{
+		name:    "POST_64KiB",
+		request: fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\n\r\n%s", 64<<10, strings.Repeat("x", 64<<10)),
+	},
+}
+
+// BenchmarkRequestFromReader compares the original parser with the current
+// one, reading a whole request including its body:
+//
+//	go test ./internal/request -bench RequestFromReader -benchmem
+func BenchmarkRequestFromReader(b *testing.B) {
+	for _, br := range benchRequests {
+		data := []byte(br.request)
+
+		b.Run(br.name+"/legacy", func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			reader := bytes.NewReader(data)
+			for b.Loop() {
+				reader.Reset(data)
+				if _, err := legacyRequestFromReader(reader); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(br.name+"/current", func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			reader := bytes.NewReader(data)
+			for b.Loop() {
+				reader.Reset(data)
+				r, err := RequestFromReader(reader)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := r.BodyBytes(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkReaderKeepAlive reads requests one after another off a single
+// connection and streams each body without buffering it, as the server
+// does.
+func BenchmarkReaderKeepAlive(b *testing.B) {
+	for _, br := range benchRequests {
+		b.Run(br.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(br.request)))
+			reader := NewReader(&repeatReader{data: []byte(br.request)})
+			defer reader.Release()
+			for b.Loop() {
+				r, err := reader.ReadRequest()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, r.Body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// repeatReader sends data over and over, like a client pipelining the same
+// request forever.
+type repeatReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.data[r.pos:])
+		n += c
+		r.pos = (r.pos + c) % len(r.data)
+	}
+	return n, nil
+}
@@ -0,0 +1,134 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// NoBody is the Body of requests that don't have one. It is always at EOF.
+var NoBody = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+var ERROR_BODY_CLOSED = fmt.Errorf("read on closed body")
+
+// body streams a request body off the connection, pulling more bytes
+// through the parser only as the handler asks for them.
+type body struct {
+	reader  *Reader
+	request *Request
+	err     error
+	closed  bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ERROR_BODY_CLOSED
+	}
+	return b.read(p)
+}
+
+func (b *body) read(p []byte) (int, error) {
+	r, rd := b.request, b.reader
+	for {
+		if r.state == StateDone {
+			return 0, io.EOF
+		}
+		if b.err != nil {
+			return 0, b.err
+		}
+
+		if remaining := r.bodyRemaining(); remaining > 0 {
+			if len(p) == 0 {
+				return 0, nil
+			}
+			p := p[:min(int64(len(p)), remaining)]
+
+			var n int
+			switch {
+			case len(rd.buffered()) > 0:
+				n = copy(p, rd.buffered())
+				rd.discard(n)
+			case len(p) >= len(rd.buf) && !rd.eof:
+				// Large reads skip the buffer and go straight into p.
+				var err error
+				n, err = rd.reader.Read(p)
+				if err == io.EOF {
+					rd.eof = true
+				} else if err != nil {
+					b.err = err
+				}
+			default:
+				b.fill()
+				continue
+			}
+			if n == 0 {
+				continue
+			}
+			r.consumeBody(n)
+			return n, nil
+		}
+
+		// Between chunks: let the parser work through the framing.
+		state := r.state
+		n, err := rd.advance(r)
+		if err != nil {
+			b.err = err
+			continue
+		}
+		if n == 0 && r.state == state {
+			b.fill()
+		}
+	}
+}
+
+// fill reads more of the body from the connection, recording why the body
+// can't be completed if the connection ended.
+func (b *body) fill() {
+	err := b.reader.fill()
+	if err != io.EOF {
+		b.err = err
+		return
+	}
+	r := b.request
+	if r.inChunkedBody() {
+		b.err = ERROR_INCOMPLETE_CHUNKED_BODY
+	} else {
+		b.err = fmt.Errorf("%w: read %d of %d bytes", ERROR_INCOMPLETE_BODY, r.bodyRead, r.contentLength)
+	}
+}
+
+// Close discards the unread rest of the body so the connection is
+// positioned at the next request. It returns the error that stopped it, if
+// the body couldn't be read to its end.
+func (b *body) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	_, err := io.Copy(io.Discard, readerFunc(b.read))
+	return err
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// BodyBytes reads the rest of the body into memory. It is a convenience for
+// handlers that want the whole body at once; Body is replaced with a reader
+// over the same bytes, so later calls return them again.
+func (r *Request) BodyBytes() ([]byte, error) {
+	if r.Body == NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
@@ -0,0 +1,283 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// This file keeps a copy of the original parser, before the buffer and
+// header rewrite, as a baseline for the benchmarks. It is only changed to
+// drop the stdout dump of the buffer, which would drown the measurements.
+
+type legacyHeaders struct {
+	headers map[string]string
+}
+
+func newLegacyHeaders() *legacyHeaders {
+	return &legacyHeaders{
+		headers: map[string]string{},
+	}
+}
+
+func (h *legacyHeaders) Get(name string) (string, bool) {
+	str, ok := h.headers[strings.ToLower(name)]
+	return str, ok
+}
+
+func (h *legacyHeaders) Set(name string, value string) {
+	v, ok := h.Get(name)
+	name = strings.ToLower(name)
+
+	if !ok {
+		h.headers[name] = value
+		return
+	} else {
+		h.headers[name] = fmt.Sprintf("%s,%s", v, value)
+	}
+}
+
+func legacyIsToken(s []byte) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, r := range s {
+		if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			continue
+		}
+		switch r {
+		case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~':
+			continue
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func legacyParseHeader(fieldLine []byte) (string, string, error) {
+	parts := bytes.SplitN(fieldLine, []byte(":"), 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("malformed header")
+	}
+	name := parts[0]
+	value := bytes.TrimSpace(parts[1])
+	if !bytes.Equal(name, bytes.TrimSpace(name)) {
+		return "", "", fmt.Errorf("malformed key in header")
+	}
+
+	return string(name), string(value), nil
+}
+
+func (h *legacyHeaders) Parse(data []byte) (int, bool, error) {
+	read := 0
+	isDone := false
+
+	for {
+		idx := bytes.Index(data[read:], SEPARATOR)
+
+		if idx == -1 {
+			break
+		}
+		// Empty header
+		if idx == 0 {
+			isDone = true
+			read += len(SEPARATOR)
+			break
+		}
+
+		name, value, err := legacyParseHeader(data[read : read+idx])
+
+		if err != nil {
+			return 0, false, err
+		}
+
+		if !legacyIsToken([]byte(name)) {
+			return 0, false, fmt.Errorf("malformed header name")
+		}
+
+		read += idx + len(SEPARATOR)
+
+		h.Set(name, value)
+	}
+
+	return read, isDone, nil
+}
+
+type legacyRequest struct {
+	RequestLine RequestLine
+	Headers     *legacyHeaders
+	state       parserState
+	Body        string
+}
+
+func legacyGetInt(headers *legacyHeaders, name string, defaultValue int) int {
+	valueStr, exists := headers.Get(name)
+
+	if !exists {
+		return defaultValue
+	}
+
+	value, err := strconv.Atoi(valueStr)
+
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func legacyParseRequestLine(req []byte) (*RequestLine, int, error) {
+	idx := bytes.Index(req, SEPARATOR)
+	if idx == -1 {
+		return nil, 0, nil
+	}
+
+	startLine := req[:idx]
+	consumed := idx + len(SEPARATOR)
+
+	parts := bytes.Split(startLine, []byte(" "))
+	if len(parts) != 3 {
+		return nil, 0, ERROR_BAD_START_LINE
+	}
+
+	httpParts := bytes.Split(parts[2], []byte("/"))
+	if len(httpParts) != 2 || string(httpParts[0]) != "HTTP" || string(httpParts[1]) != "1.1" {
+		return nil, 0, ERROR_UNSUPPORTED_HPPT_VERSION
+	}
+
+	rl := &RequestLine{
+		Method:        string(parts[0]),
+		RequestTarget: string(parts[1]),
+		HttpVersion:   string(httpParts[1]),
+	}
+
+	if !rl.ValidMethod() {
+		return nil, 0, ERROR_BAD_START_LINE
+	}
+
+	return rl, consumed, nil
+}
+
+func (r *legacyRequest) parse(data []byte) (int, error) {
+	read := 0
+
+outer:
+	for {
+		currData := data[read:]
+		if len(currData) == 0 {
+			break outer
+		}
+
+		switch r.state {
+		case StateInit:
+			rl, consumed, err := legacyParseRequestLine(currData)
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			if consumed == 0 {
+				break outer
+			}
+			r.RequestLine = *rl
+			read += consumed
+
+			r.state = StateHeader
+
+		case StateHeader:
+			n, done, err := r.Headers.Parse(currData)
+
+			read += n
+
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			if n == 0 {
+				break outer
+			}
+
+			if done {
+				length := legacyGetInt(r.Headers, "content-length", 0)
+				if length == 0 {
+					r.state = StateDone
+					break outer
+				}
+				r.state = StateBody
+				continue
+			} else {
+				break outer
+			}
+
+		case StateBody:
+			length := legacyGetInt(r.Headers, "content-length", 0)
+			if length == 0 {
+				r.state = StateDone
+				break outer
+			}
+			remaining := min(length-len(r.Body), len(currData))
+			r.Body += string(currData[:remaining])
+			read += remaining
+
+			if len(r.Body) == length {
+				r.state = StateDone
+			}
+
+		case StateDone:
+			break outer
+		case StateError:
+			return 0, ERROR_BAD_START_LINE
+
+		default:
+			panic("skill issue programming")
+		}
+	}
+
+	return read, nil
+}
+
+func (r *legacyRequest) done() bool {
+	return r.state == StateDone || r.state == StateError
+}
+
+func legacyRequestFromReader(reader io.Reader) (*legacyRequest, error) {
+	request := &legacyRequest{
+		state:   StateInit,
+		Headers: newLegacyHeaders(),
+	}
+
+	buf := make([]byte, 1024)
+	readToIdx := 0
+	for !request.done() {
+		if readToIdx >= len(buf) {
+			newBuf := make([]byte, len(buf)*2)
+			copy(newBuf, buf)
+			buf = newBuf
+		}
+
+		n, err := reader.Read(buf[readToIdx:])
+		if err == io.EOF {
+			contentLength := legacyGetInt(request.Headers, "content-length", 0)
+			if contentLength > 0 && len(request.Body) != contentLength {
+				return nil, fmt.Errorf("body length (%d) does not match Content-Length header (%d)", len(request.Body), contentLength)
+			}
+			request.state = StateDone
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		readToIdx += n
+
+		readN, err := request.parse(buf[:readToIdx])
+		if err != nil {
+			return nil, err
+		}
+
+		copy(buf, buf[readN:readToIdx])
+		readToIdx -= readN
+	}
+
+	return request, nil
+}
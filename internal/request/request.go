@@ -2,10 +2,12 @@ package request
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/t3nna/http-from-tcp/internal/headers"
 )
@@ -20,37 +22,124 @@ type RequestLine struct {
 
 type Request struct {
 	RequestLine RequestLine
-	Headers     *headers.Headers
-	state       parserState
-	Body        string
+	// Target is RequestLine.RequestTarget parsed.
+	Target  Target
+	Headers *headers.Headers
+	// Trailers holds the fields sent after a chunked body, if any. They are
+	// only complete once Body has been read to EOF.
+	Trailers *headers.Headers
+	// Body streams the request body, decoded from Content-Length or chunked
+	// framing. It is NoBody for requests without one. The server closes it.
+	Body io.ReadCloser
+	// Host is the host[:port] the request is for: the authority of an
+	// absolute- or authority-form target, otherwise the Host header. It is
+	// empty only for HTTP/1.0 requests that sent no Host.
+	Host string
+	// RemoteAddr is the client's address, set by the server.
+	RemoteAddr string
+	state      parserState
+
+	// contentLength is the parsed Content-Length, bodyRead counts every
+	// decoded body byte handed out so far.
+	contentLength  int64
+	bodyRead       int64
+	chunkRemaining uint64
+	limits         Limits
+	headerBytes    int
+	headerCount    int
+	pathValues     map[string]string
+	ctx            context.Context
 }
 
-func getInt(headers *headers.Headers, name string, defaultValue int) int {
-	valueStr, exists := headers.Get(name)
+// Context returns the request's context. The server cancels it when the
+// client disconnects, the request deadline passes or the server shuts down.
+// It is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
 
-	if !exists {
-		return defaultValue
+// WithContext returns a shallow copy of r using ctx, e.g. for middleware
+// that attaches values to the context.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
 	}
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
+}
 
-	value, err := strconv.Atoi(valueStr)
+// PathValue returns the value matched for a named path parameter by the
+// router, or "" if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
 
-	if err != nil {
-		return defaultValue
+func (r *Request) SetPathValue(name string, value string) {
+	if r.pathValues == nil {
+		r.pathValues = map[string]string{}
 	}
-	return value
-
+	r.pathValues[name] = value
 }
-func newRequest() *Request {
+
+func newRequest(limits Limits) *Request {
 	return &Request{
-		state:   StateInit,
-		Headers: headers.NewHeaders(),
+		limits:   limits,
+		state:    StateInit,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		Body:     NoBody,
 	}
 }
 
 var SEPARATOR = []byte("\r\n")
 var ERROR_BAD_START_LINE = fmt.Errorf("malformed request-line")
 var ERROR_UNSUPPORTED_HPPT_VERSION = fmt.Errorf("unsupported http version")
-var bufferSize = 1024
+var ERROR_UNSUPPORTED_TRANSFER_ENCODING = fmt.Errorf("unsupported transfer-encoding")
+var ERROR_LENGTH_AND_CHUNKED = fmt.Errorf("both content-length and chunked transfer-encoding present")
+var ERROR_BAD_CHUNK = fmt.Errorf("malformed chunk")
+var ERROR_INCOMPLETE_CHUNKED_BODY = fmt.Errorf("connection closed inside chunked body")
+var ERROR_INCOMPLETE_BODY = fmt.Errorf("connection closed before end of body")
+var ERROR_REQUEST_LINE_TOO_LONG = fmt.Errorf("request-line too long")
+var ERROR_HEADERS_TOO_LARGE = fmt.Errorf("header section too large")
+var ERROR_TOO_MANY_HEADERS = fmt.Errorf("too many header fields")
+var ERROR_BODY_TOO_LARGE = fmt.Errorf("body too large")
+var ERROR_DUPLICATE_HOST = fmt.Errorf("more than one host header")
+var ERROR_MISSING_HOST = fmt.Errorf("missing host header")
+var ERROR_INVALID_HOST = fmt.Errorf("malformed host header")
+var ERROR_CONFLICTING_CONTENT_LENGTH = fmt.Errorf("conflicting content-length values")
+
+// maxChunkSizeLine bounds a chunk-size line including its extensions.
+const maxChunkSizeLine = 4096
+
+// Limits caps how much a client may send in one request. A zero field
+// means no limit.
+type Limits struct {
+	MaxRequestLineBytes int
+	// MaxHeaderBytes covers all header field lines, and trailer lines of a
+	// chunked body.
+	MaxHeaderBytes int
+	// MaxHeaderCount covers header fields and trailer fields.
+	MaxHeaderCount int
+	MaxBodyBytes   int64
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		MaxRequestLineBytes: 8 << 10,
+		MaxHeaderBytes:      64 << 10,
+		MaxHeaderCount:      100,
+		MaxBodyBytes:        10 << 20,
+	}
+}
+
+// bufferSize is the initial size of a Reader's buffer; it also bounds how
+// much is read from the connection at once.
+var bufferSize = 4096
 
 const (
 	StateInit   parserState = "init"
@@ -58,45 +147,100 @@ const (
 	StateError  parserState = "error"
 	StateHeader parserState = "headers"
 	StateBody   parserState = "body"
+
+	StateChunkSize    parserState = "chunk-size"
+	StateChunkData    parserState = "chunk-data"
+	StateChunkDataEnd parserState = "chunk-data-end"
+	StateTrailers     parserState = "trailers"
 )
 
 func (rl *RequestLine) ValidHttp() bool {
-	return rl.HttpVersion == "1.1"
+	return rl.HttpVersion == "1.1" || rl.HttpVersion == "1.0"
+}
+
+// parseHttpVersion checks an "HTTP/x.y" version. Any 1.x other than 1.0 is
+// handled as 1.1; well-formed versions with another major number get
+// ERROR_UNSUPPORTED_HPPT_VERSION, anything else is a malformed request-line.
+func parseHttpVersion(version []byte) (string, error) {
+	if len(version) != len("HTTP/x.y") || !bytes.HasPrefix(version, []byte("HTTP/")) ||
+		!isDigit(version[5]) || version[6] != '.' || !isDigit(version[7]) {
+		return "", ERROR_BAD_START_LINE
+	}
+	switch {
+	case string(version[5:]) == "1.0":
+		return "1.0", nil
+	case version[5] == '1':
+		// A later 1.x minor version is understood as the highest one we
+		// speak (RFC 9110, 2.5).
+		return "1.1", nil
+	}
+	return "", ERROR_UNSUPPORTED_HPPT_VERSION
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
 }
 func (rl *RequestLine) ValidMethod() bool {
 	return rl.Method == strings.ToUpper(rl.Method)
 }
 
-func parseRequestLine(req []byte) (*RequestLine, int, error) {
+// methods interns the standard method names so parsing them doesn't
+// allocate.
+var methods = map[string]string{}
+
+func init() {
+	for _, m := range []string{"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH"} {
+		methods[m] = m
+	}
+}
+
+func internMethod(b []byte) string {
+	if m, ok := methods[string(b)]; ok {
+		return m
+	}
+	return string(b)
+}
+
+func parseRequestLine(req []byte) (RequestLine, int, error) {
 	idx := bytes.Index(req, SEPARATOR)
 	if idx == -1 {
-		return nil, 0, nil
+		return RequestLine{}, 0, nil
 	}
 
 	startLine := req[:idx]
 	consumed := idx + len(SEPARATOR)
 
-	parts := bytes.Split(startLine, []byte(" "))
-	if len(parts) != 3 {
-		return nil, 0, ERROR_BAD_START_LINE
+	// method SP request-target SP HTTP-version, with exactly two spaces.
+	sp1 := bytes.IndexByte(startLine, ' ')
+	if sp1 == -1 {
+		return RequestLine{}, 0, ERROR_BAD_START_LINE
+	}
+	sp2 := bytes.IndexByte(startLine[sp1+1:], ' ')
+	if sp2 == -1 {
+		return RequestLine{}, 0, ERROR_BAD_START_LINE
+	}
+	sp2 += sp1 + 1
+	method, target, version := startLine[:sp1], startLine[sp1+1:sp2], startLine[sp2+1:]
+	if bytes.IndexByte(version, ' ') != -1 {
+		return RequestLine{}, 0, ERROR_BAD_START_LINE
 	}
 
-	httpParts := bytes.Split(parts[2], []byte("/"))
-	if len(httpParts) != 2 || string(httpParts[0]) != "HTTP" || string(httpParts[1]) != "1.1" {
-		return nil, 0, ERROR_UNSUPPORTED_HPPT_VERSION
+	httpVersion, err := parseHttpVersion(version)
+	if err != nil {
+		return RequestLine{}, 0, err
 	}
 
-	rl := &RequestLine{
-		Method:        string(parts[0]),
-		RequestTarget: string(parts[1]),
-		HttpVersion:   string(httpParts[1]),
+	rl := RequestLine{
+		Method:        internMethod(method),
+		RequestTarget: string(target),
+		HttpVersion:   httpVersion,
 	}
 
 	if !rl.ValidMethod() {
-		return nil, 0, ERROR_BAD_START_LINE
+		return RequestLine{}, 0, ERROR_BAD_START_LINE
 	}
 	//if !rl.ValidHttp() {
-	//	return nil, 0, ERROR_BAD_START_LINE
+	//	return RequestLine{}, 0, ERROR_BAD_START_LINE
 	//}
 
 	return rl, consumed, nil
@@ -120,10 +264,24 @@ outer:
 				r.state = StateError
 				return 0, err
 			}
+			lineLen := consumed - len(SEPARATOR)
+			if consumed == 0 {
+				lineLen = len(currData)
+			}
+			if r.limits.MaxRequestLineBytes > 0 && lineLen > r.limits.MaxRequestLineBytes {
+				r.state = StateError
+				return 0, ERROR_REQUEST_LINE_TOO_LONG
+			}
 			if consumed == 0 {
 				break outer
 			}
-			r.RequestLine = *rl
+			target, err := parseTarget(rl.Method, rl.RequestTarget)
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			r.RequestLine = rl
+			r.Target = target
 			read += consumed
 
 			r.state = StateHeader
@@ -137,37 +295,109 @@ outer:
 				r.state = StateError
 				return 0, err
 			}
+			if err := r.countHeaderBytes(currData, n, done); err != nil {
+				r.state = StateError
+				return 0, err
+			}
 			if n == 0 {
 				break outer
 			}
 
-			if done {
-				// Headers are complete, check if we need a body
-				length := getInt(r.Headers, "content-length", 0)
-				if length == 0 {
-					r.state = StateDone
-					break outer
-				}
-				r.state = StateBody
-				// Continue loop to process body
-				continue
-			} else {
+			if !done {
+				break outer
+			}
+
+			if err := r.checkHeaders(); err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			chunked, err := r.isChunked()
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			if chunked {
+				r.state = StateChunkSize
 				break outer
 			}
 
-		case StateBody:
-			length := getInt(r.Headers, "content-length", 0)
-			if length == 0 {
+			// Headers are complete, check if we need a body
+			length, err := r.Headers.ContentLength()
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			r.contentLength = max(length, 0)
+			if r.limits.MaxBodyBytes > 0 && r.contentLength > r.limits.MaxBodyBytes {
+				r.state = StateError
+				return 0, ERROR_BODY_TOO_LARGE
+			}
+			if r.contentLength == 0 {
 				r.state = StateDone
+			} else {
+				r.state = StateBody
+			}
+			// Stop at the end of the head; the body is read straight out of
+			// the buffer by Body.
+			break outer
+
+		case StateBody, StateChunkData:
+			break outer
+
+		case StateChunkSize:
+			idx := bytes.Index(currData, SEPARATOR)
+			if idx == -1 {
+				if len(currData) > maxChunkSizeLine {
+					r.state = StateError
+					return 0, ERROR_BAD_CHUNK
+				}
+				break outer
+			}
+			size, err := parseChunkSize(currData[:idx])
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			if r.limits.MaxBodyBytes > 0 && size > uint64(r.limits.MaxBodyBytes-r.bodyRead) {
+				r.state = StateError
+				return 0, ERROR_BODY_TOO_LARGE
+			}
+			read += idx + len(SEPARATOR)
+
+			if size == 0 {
+				r.state = StateTrailers
+			} else {
+				r.chunkRemaining = size
+				r.state = StateChunkData
+			}
+
+		case StateChunkDataEnd:
+			if len(currData) < len(SEPARATOR) {
 				break outer
 			}
-			remaining := min(length-len(r.Body), len(currData))
-			r.Body += string(currData[:remaining])
-			read += remaining
+			if !bytes.HasPrefix(currData, SEPARATOR) {
+				r.state = StateError
+				return 0, ERROR_BAD_CHUNK
+			}
+			read += len(SEPARATOR)
+			r.state = StateChunkSize
 
-			if len(r.Body) == length {
+		case StateTrailers:
+			n, done, err := r.Trailers.Parse(currData)
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			if err := r.countHeaderBytes(currData, n, done); err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			read += n
+
+			if done {
 				r.state = StateDone
 			}
+			break outer
 
 		case StateDone:
 			break outer
@@ -184,49 +414,332 @@ outer:
 
 }
 
+// bodyRemaining returns how many body bytes can be copied out before the
+// parser has to look at framing again: the rest of the Content-Length body
+// or of the current chunk.
+func (r *Request) bodyRemaining() int64 {
+	switch r.state {
+	case StateBody:
+		return r.contentLength - r.bodyRead
+	case StateChunkData:
+		return int64(r.chunkRemaining)
+	}
+	return 0
+}
+
+// consumeBody records n body bytes handed out by Body.
+func (r *Request) consumeBody(n int) {
+	r.bodyRead += int64(n)
+	switch r.state {
+	case StateBody:
+		if r.bodyRead == r.contentLength {
+			r.state = StateDone
+		}
+	case StateChunkData:
+		r.chunkRemaining -= uint64(n)
+		if r.chunkRemaining == 0 {
+			r.state = StateChunkDataEnd
+		}
+	}
+}
+
+// countHeaderBytes charges the field lines Parse consumed from data against
+// the header limits. A partial line still waiting for its CRLF counts too, so
+// a client can't grow the buffer by never ending a line.
+func (r *Request) countHeaderBytes(data []byte, consumed int, done bool) error {
+	lines := bytes.Count(data[:consumed], SEPARATOR)
+	if done {
+		// The empty line ending the section is not a field.
+		lines--
+	}
+	r.headerCount += lines
+	r.headerBytes += consumed
+
+	pending := 0
+	if !done {
+		pending = len(data) - consumed
+	}
+	if r.limits.MaxHeaderBytes > 0 && r.headerBytes+pending > r.limits.MaxHeaderBytes {
+		return ERROR_HEADERS_TOO_LARGE
+	}
+	if r.limits.MaxHeaderCount > 0 && r.headerCount > r.limits.MaxHeaderCount {
+		return ERROR_TOO_MANY_HEADERS
+	}
+	return nil
+}
+
+// checkHeaders rejects fields that may only occur once but don't: a second
+// Host, or Content-Length values that disagree. HTTP/1.1 requests must carry
+// a well-formed Host, which also fills in r.Host. Repeated identical
+// Content-Length values are collapsed into one, as RFC 9110 allows.
+func (r *Request) checkHeaders() error {
+	hosts := r.Headers.Values("host")
+	switch {
+	case len(hosts) > 1:
+		return ERROR_DUPLICATE_HOST
+	case len(hosts) == 0 && r.RequestLine.HttpVersion != "1.0":
+		return ERROR_MISSING_HOST
+	case len(hosts) == 1 && !validHost(hosts[0]):
+		return ERROR_INVALID_HOST
+	}
+	// A target that names its authority overrides Host (RFC 9112, 3.2.2).
+	if r.Target.Authority != "" {
+		r.Host = r.Target.Authority
+	} else if len(hosts) == 1 {
+		r.Host = hosts[0]
+	}
+
+	lengths := r.Headers.Values("content-length")
+	if len(lengths) == 0 {
+		return nil
+	}
+	values := strings.Split(strings.Join(lengths, ","), ",")
+	for _, v := range values[1:] {
+		if strings.TrimSpace(v) != strings.TrimSpace(values[0]) {
+			return ERROR_CONFLICTING_CONTENT_LENGTH
+		}
+	}
+	if len(values) > 1 {
+		r.Headers.Set("Content-Length", strings.TrimSpace(values[0]))
+	}
+	return nil
+}
+
+// isChunked reports whether the body uses chunked framing. Any other
+// transfer coding is rejected, and so is chunked alongside Content-Length:
+// two framings let a proxy and this server disagree on where the request
+// ends, which is how requests get smuggled.
+func (r *Request) isChunked() (bool, error) {
+	te, ok := r.Headers.Get("transfer-encoding")
+	if !ok {
+		return false, nil
+	}
+	if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+		return false, ERROR_UNSUPPORTED_TRANSFER_ENCODING
+	}
+	if _, ok := r.Headers.Get("content-length"); ok {
+		return false, ERROR_LENGTH_AND_CHUNKED
+	}
+	return true, nil
+}
+
+func (r *Request) inChunkedBody() bool {
+	switch r.state {
+	case StateChunkSize, StateChunkData, StateChunkDataEnd, StateTrailers:
+		return true
+	}
+	return false
+}
+
+// parseChunkSize parses a chunk-size line, ignoring any chunk extensions
+// ("1a;name=value").
+func parseChunkSize(line []byte) (uint64, error) {
+	if idx := bytes.IndexByte(line, ';'); idx != -1 {
+		line = line[:idx]
+	}
+	line = bytes.TrimRight(line, " \t")
+	if len(line) == 0 {
+		return 0, ERROR_BAD_CHUNK
+	}
+
+	size, err := strconv.ParseUint(string(line), 16, 63)
+	if err != nil {
+		return 0, ERROR_BAD_CHUNK
+	}
+	return size, nil
+}
+
 func (r *Request) done() bool {
 	return r.state == StateDone || r.state == StateError
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	request := newRequest()
-
-	// NOTE: buffer could get overrun...
-	buf := make([]byte, bufferSize)
-	readToIdx := 0
-	for !request.done() {
-		if readToIdx >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
-		}
+// headersDone reports whether the parser got past the header section.
+func (r *Request) headersDone() bool {
+	return r.state != StateInit && r.state != StateHeader
+}
 
-		n, err := reader.Read(buf[readToIdx:])
-		if err == io.EOF {
-			// Validate body length matches Content-Length before finishing
-			contentLength := getInt(request.Headers, "content-length", 0)
-			if contentLength > 0 && len(request.Body) != contentLength {
-				return nil, fmt.Errorf("body length (%d) does not match Content-Length header (%d)", len(request.Body), contentLength)
+// bufferPool recycles read buffers across connections. Only buffers of the
+// default size are pooled; ones grown for a large head are left to the GC.
+var bufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, bufferSize)
+		return &buf
+	},
+}
+
+// Reader parses consecutive requests from a single connection. Bytes read
+// past the end of one request (a pipelined follow-up) stay buffered and are
+// the start of the next one. Parsing works on views of the buffer; only the
+// fields kept on the Request are copied out.
+type Reader struct {
+	reader io.Reader
+	// buf[start:end] holds bytes read but not yet parsed. pooled is the
+	// pool's buffer, which buf keeps using until it has to grow.
+	pooled *[]byte
+	buf    []byte
+	start  int
+	end    int
+	eof    bool
+
+	// current is the body of the last request returned, which must be
+	// consumed before the next request can be parsed.
+	current *body
+
+	// Limits applied to every request read. NewReader sets DefaultLimits.
+	Limits Limits
+	// Debug, if set, receives the raw head of every request read.
+	Debug io.Writer
+}
+
+func NewReader(reader io.Reader) *Reader {
+	pooled := bufferPool.Get().(*[]byte)
+	return &Reader{
+		reader: reader,
+		pooled: pooled,
+		buf:    *pooled,
+		Limits: DefaultLimits(),
+	}
+}
+
+// Release returns the read buffer to the pool. The Reader and the body of
+// the last request must not be used afterwards.
+func (r *Reader) Release() {
+	if r.current != nil {
+		r.current.closed = true
+		r.current = nil
+	}
+	if r.pooled != nil {
+		bufferPool.Put(r.pooled)
+		r.pooled = nil
+	}
+	r.buf = nil
+	r.start, r.end = 0, 0
+}
+
+func (r *Reader) buffered() []byte {
+	return r.buf[r.start:r.end]
+}
+
+// discard drops n parsed bytes from the front of the buffer.
+func (r *Reader) discard(n int) {
+	r.start += n
+	if r.start == r.end {
+		r.start, r.end = 0, 0
+	}
+}
+
+// advance feeds the buffered bytes to the request's parser and drops what
+// it consumed. It returns how many bytes that was.
+func (r *Reader) advance(request *Request) (int, error) {
+	readN, err := request.parse(r.buffered())
+	if err != nil {
+		return 0, err
+	}
+	r.discard(readN)
+	return readN, nil
+}
+
+// fill reads more from the connection. It returns io.EOF only once the
+// connection is done and nothing new was read.
+func (r *Reader) fill() error {
+	if r.eof {
+		return io.EOF
+	}
+
+	if r.end == len(r.buf) {
+		if r.start > 0 {
+			// Make room by sliding the unparsed bytes to the front, which
+			// only happens once per buffer's worth of data.
+			r.end = copy(r.buf, r.buffered())
+			r.start = 0
+		} else {
+			// A single line doesn't fit. With Limits set the parser errors
+			// out before the buffer can grow without bound.
+			newBuf := make([]byte, len(r.buf)*2)
+			copy(newBuf, r.buf)
+			r.buf = newBuf
+			// Keep the pool to buffers of the default size. The pooled one
+			// is already back after an earlier grow.
+			if r.pooled != nil {
+				bufferPool.Put(r.pooled)
+				r.pooled = nil
 			}
-			request.state = StateDone
-			break
 		}
-		if err != nil {
+	}
+
+	n, err := r.reader.Read(r.buf[r.end:])
+	r.end += n
+	if err == io.EOF {
+		r.eof = true
+		if n > 0 {
+			return nil
+		}
+	}
+	return err
+}
+
+// ReadRequest parses the next request up to the end of its headers; the
+// body is streamed through Request.Body. Whatever the caller left unread
+// of the previous body is discarded first. It returns io.EOF when the
+// connection ends cleanly before a new request starts.
+func (r *Reader) ReadRequest() (*Request, error) {
+	if r.current != nil {
+		if err := r.current.Close(); err != nil {
 			return nil, err
 		}
-		readToIdx += n
+		r.current = nil
+	}
 
-		readN, err := request.parse(buf[:readToIdx])
+	request := newRequest(r.Limits)
+	var head []byte
+	for {
+		// Parse what is buffered first, a pipelined request may already be
+		// complete without touching the connection.
+		data := r.buffered()
+		n, err := r.advance(request)
 		if err != nil {
 			return nil, err
 		}
+		if r.Debug != nil {
+			head = append(head, data[:n]...)
+		}
+		if request.headersDone() {
+			break
+		}
+
+		if err := r.fill(); err == io.EOF {
+			if request.state == StateInit && r.start == r.end {
+				// Peer closed the connection between requests.
+				return nil, io.EOF
+			}
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if r.Debug != nil {
+		r.Debug.Write(head)
+	}
 
-		copy(buf, buf[readN:readToIdx])
-		readToIdx -= readN
+	if request.state != StateDone {
+		r.current = &body{reader: r, request: request}
+		request.Body = r.current
 	}
-	fmt.Println("=========================================================")
-	fmt.Println(string(buf))
-	fmt.Println("=========================================================")
+	return request, nil
+}
 
+// RequestFromReader parses a single request from reader, reading the whole
+// body into memory so that a truncated request is reported as an error.
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	r := NewReader(reader)
+	defer r.Release()
+
+	request, err := r.ReadRequest()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := request.BodyBytes(); err != nil {
+		return nil, err
+	}
 	return request, nil
 }
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/t3nna/http-from-tcp/internal/headers"
 	"io"
+	"strings"
 	"testing"
 )
 
@@ -256,7 +258,7 @@ func TestEdgeCases(t *testing.T) {
 
 	t.Run("Very long request with small chunks", func(t *testing.T) {
 		// Create a request with a very long path
-		longPath := "/" + string(make([]byte, 500)) // 500 byte path
+		longPath := "/" + strings.Repeat("a", 500) // 500 byte path
 		request := "GET " + longPath + " HTTP/1.1\r\nHost: localhost\r\n\r\n"
 		reader := &chunkReader{
 			data:            request,
@@ -293,9 +295,38 @@ func TestErrorCases(t *testing.T) {
 		}
 		r, err := RequestFromReader(reader)
 		require.Error(t, err, "Should error on unsupported HTTP version")
+		assert.ErrorIs(t, err, ERROR_UNSUPPORTED_HPPT_VERSION)
 		assert.Nil(t, r)
 	})
 
+	t.Run("HTTP/1.0", func(t *testing.T) {
+		r, err := RequestFromReader(&chunkReader{
+			data:            "GET / HTTP/1.0\r\n\r\n",
+			numBytesPerRead: 3,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	})
+
+	t.Run("Later HTTP/1.x is handled as 1.1", func(t *testing.T) {
+		r, err := RequestFromReader(&chunkReader{
+			data:            "GET / HTTP/1.2\r\nHost: localhost\r\n\r\n",
+			numBytesPerRead: 3,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
+	})
+
+	t.Run("Malformed HTTP version", func(t *testing.T) {
+		for _, version := range []string{"HTTP/1", "HTTP/1.1.1", "HTTPS/1.1", "http/1.1", "HTTP/a.b"} {
+			_, err := RequestFromReader(&chunkReader{
+				data:            "GET / " + version + "\r\n\r\n",
+				numBytesPerRead: 3,
+			})
+			assert.ErrorIs(t, err, ERROR_BAD_START_LINE, version)
+		}
+	})
+
 	t.Run("Invalid method - lowercase", func(t *testing.T) {
 		request := "get / HTTP/1.1\r\nHost: localhost\r\n\r\n"
 		reader := &chunkReader{
@@ -354,6 +385,13 @@ func TestChunkBoundaryAtCriticalPoints(t *testing.T) {
 	}
 }
 
+func readBody(t *testing.T, r *Request) string {
+	t.Helper()
+	data, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	return string(data)
+}
+
 func TestParseBody(t *testing.T) {
 	// Test: Standard Body
 	reader := &chunkReader{
@@ -367,7 +405,7 @@ func TestParseBody(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "hello world!\n", readBody(t, r))
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -381,3 +419,457 @@ func TestParseBody(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.Error(t, err)
 }
+
+// TestPipelinedRequests sends several requests in a single write and checks
+// that the reader hands them out one by one without dropping leftover bytes
+func TestPipelinedRequests(t *testing.T) {
+	pipelined := "GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /second HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /third HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	for _, chunkSize := range []int{1, 3, 7, 16, len(pipelined)} {
+		t.Run(fmt.Sprintf("chunk_size_%d", chunkSize), func(t *testing.T) {
+			reader := NewReader(&chunkReader{
+				data:            pipelined,
+				numBytesPerRead: chunkSize,
+			})
+
+			r, err := reader.ReadRequest()
+			require.NoError(t, err)
+			assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+			assert.Equal(t, NoBody, r.Body)
+
+			r, err = reader.ReadRequest()
+			require.NoError(t, err)
+			assert.Equal(t, "POST", r.RequestLine.Method)
+			assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+			assert.Equal(t, "hello", readBody(t, r))
+
+			r, err = reader.ReadRequest()
+			require.NoError(t, err)
+			assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+
+			r, err = reader.ReadRequest()
+			assert.ErrorIs(t, err, io.EOF)
+			assert.Nil(t, r)
+		})
+	}
+
+	t.Run("Truncated follow-up request", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data:            "GET /first HTTP/1.1\r\nHost: localhost\r\n\r\nPOST /second HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhi",
+			numBytesPerRead: 1024,
+		})
+
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+
+		r, err = reader.ReadRequest()
+		require.NoError(t, err)
+		_, err = io.ReadAll(r.Body)
+		require.ErrorIs(t, err, ERROR_INCOMPLETE_BODY)
+	})
+}
+
+func TestStreamingBody(t *testing.T) {
+	t.Run("Request is returned before the body arrives", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		reader := NewReader(pr)
+		go pw.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\n"))
+
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "/upload", r.RequestLine.RequestTarget)
+
+		go func() {
+			pw.Write([]byte("hello "))
+			pw.Write([]byte("world"))
+			pw.Write([]byte("GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+			pw.Close()
+		}()
+		assert.Equal(t, "hello world", readBody(t, r))
+
+		r, err = reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+	})
+
+	t.Run("Unread body is skipped", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data: "POST /a HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+				"POST /b HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+				"GET /c HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			numBytesPerRead: 2,
+		})
+		for _, target := range []string{"/a", "/b", "/c"} {
+			r, err := reader.ReadRequest()
+			require.NoError(t, err)
+			assert.Equal(t, target, r.RequestLine.RequestTarget)
+		}
+	})
+
+	t.Run("Partially read body", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data:            "POST /a HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhelloGET /b HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			numBytesPerRead: 64,
+		})
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		p := make([]byte, 2)
+		n, err := r.Body.Read(p)
+		require.NoError(t, err)
+		assert.Equal(t, "he", string(p[:n]))
+		require.NoError(t, r.Body.Close())
+		_, err = r.Body.Read(p)
+		assert.ErrorIs(t, err, ERROR_BODY_CLOSED)
+
+		r, err = reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+	})
+
+	t.Run("BodyBytes can be read again", func(t *testing.T) {
+		r, err := NewReader(&chunkReader{
+			data:            "POST /a HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello",
+			numBytesPerRead: 3,
+		}).ReadRequest()
+		require.NoError(t, err)
+		data, err := r.BodyBytes()
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+		assert.Equal(t, "hello", readBody(t, r))
+	})
+}
+
+func TestParseChunkedBody(t *testing.T) {
+	testCases := []struct {
+		name             string
+		request          string
+		expectedBody     string
+		expectedTrailers map[string]string
+	}{
+		{
+			name: "Simple chunks",
+			request: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"5\r\nhello\r\n" +
+				"7\r\n world!\r\n" +
+				"0\r\n\r\n",
+			expectedBody: "hello world!",
+		},
+		{
+			name: "Hex sizes and chunk extensions",
+			request: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"A;name=value\r\n0123456789\r\n" +
+				"1 ; ext\r\n!\r\n" +
+				"0;last\r\n\r\n",
+			expectedBody: "0123456789!",
+		},
+		{
+			name: "Trailers",
+			request: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"Trailer: X-Checksum\r\n" +
+				"\r\n" +
+				"3\r\nabc\r\n" +
+				"0\r\n" +
+				"X-Checksum: 900150983cd24fb0\r\n" +
+				"\r\n",
+			expectedBody:     "abc",
+			expectedTrailers: map[string]string{"x-checksum": "900150983cd24fb0"},
+		},
+	}
+
+	for _, tc := range testCases {
+		for _, chunkSize := range []int{1, 3, len(tc.request)} {
+			t.Run(fmt.Sprintf("%s/chunk_size_%d", tc.name, chunkSize), func(t *testing.T) {
+				reader := &chunkReader{
+					data:            tc.request,
+					numBytesPerRead: chunkSize,
+				}
+				r, err := RequestFromReader(reader)
+				require.NoError(t, err)
+				require.NotNil(t, r)
+				assert.Equal(t, tc.expectedBody, readBody(t, r))
+				for name, value := range tc.expectedTrailers {
+					got, ok := r.Trailers.Get(name)
+					assert.True(t, ok)
+					assert.Equal(t, value, got)
+				}
+				_, ok := r.Headers.Get("x-checksum")
+				assert.False(t, ok)
+			})
+		}
+	}
+
+	t.Run("Chunked followed by pipelined request", func(t *testing.T) {
+		reader := NewReader(&chunkReader{
+			data: "POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"2\r\nhi\r\n0\r\n\r\n" +
+				"GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			numBytesPerRead: 4,
+		})
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "hi", readBody(t, r))
+
+		r, err = reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+	})
+}
+
+func TestChunkedBodyErrors(t *testing.T) {
+	testCases := []struct {
+		name        string
+		request     string
+		expectedErr error
+	}{
+		{
+			name: "Content-Length with chunked",
+			request: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+				"Content-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"5\r\nhello\r\n0\r\n\r\n",
+			expectedErr: ERROR_LENGTH_AND_CHUNKED,
+		},
+		{
+			name: "Unsupported transfer coding",
+			request: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+				"Transfer-Encoding: gzip\r\n\r\n",
+			expectedErr: ERROR_UNSUPPORTED_TRANSFER_ENCODING,
+		},
+		{
+			name: "Invalid chunk size",
+			request: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+				"Transfer-Encoding: chunked\r\n\r\n" +
+				"zz\r\nhello\r\n0\r\n\r\n",
+			expectedErr: ERROR_BAD_CHUNK,
+		},
+		{
+			name: "Missing CRLF after chunk data",
+			request: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+				"Transfer-Encoding: chunked\r\n\r\n" +
+				"5\r\nhelloXX0\r\n\r\n",
+			expectedErr: ERROR_BAD_CHUNK,
+		},
+		{
+			name: "Connection closed before last chunk",
+			request: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+				"Transfer-Encoding: chunked\r\n\r\n" +
+				"5\r\nhello\r\n",
+			expectedErr: ERROR_INCOMPLETE_CHUNKED_BODY,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &chunkReader{
+				data:            tc.request,
+				numBytesPerRead: 3,
+			}
+			_, err := RequestFromReader(reader)
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 32,
+		MaxHeaderBytes:      64,
+		MaxHeaderCount:      3,
+		MaxBodyBytes:        10,
+	}
+
+	testCases := []struct {
+		name        string
+		request     string
+		expectedErr error
+	}{
+		{
+			name:    "Within limits",
+			request: "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n0123456789",
+		},
+		{
+			name:        "Request line too long",
+			request:     "GET /" + strings.Repeat("a", 40) + " HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			expectedErr: ERROR_REQUEST_LINE_TOO_LONG,
+		},
+		{
+			name:        "Request line never ends",
+			request:     "GET /" + strings.Repeat("a", 40),
+			expectedErr: ERROR_REQUEST_LINE_TOO_LONG,
+		},
+		{
+			name:        "Header section too large",
+			request:     "GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("b", 60) + "\r\n\r\n",
+			expectedErr: ERROR_HEADERS_TOO_LARGE,
+		},
+		{
+			name:        "Header line never ends",
+			request:     "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("b", 100),
+			expectedErr: ERROR_HEADERS_TOO_LARGE,
+		},
+		{
+			name:        "Too many header fields",
+			request:     "GET / HTTP/1.1\r\nHost: localhost\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n",
+			expectedErr: ERROR_TOO_MANY_HEADERS,
+		},
+		{
+			name:        "Content-Length over the body limit",
+			request:     "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 999999999999\r\n\r\n",
+			expectedErr: ERROR_BODY_TOO_LARGE,
+		},
+		{
+			name: "Chunked body over the body limit",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"8\r\n01234567\r\n8\r\n89abcdef\r\n0\r\n\r\n",
+			expectedErr: ERROR_BODY_TOO_LARGE,
+		},
+		{
+			name: "Trailers count against the header limits",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"0\r\nA: 1\r\nB: 2\r\n\r\n",
+			expectedErr: ERROR_TOO_MANY_HEADERS,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := NewReader(&chunkReader{
+				data:            tc.request,
+				numBytesPerRead: 4,
+			})
+			reader.Limits = limits
+			r, err := reader.ReadRequest()
+			if err == nil {
+				// Body limits are only hit while streaming the body.
+				_, err = io.ReadAll(r.Body)
+			}
+			if tc.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestReaderDebug(t *testing.T) {
+	head := "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\n"
+	var debug strings.Builder
+	reader := NewReader(&chunkReader{data: head + "hello", numBytesPerRead: 7})
+	defer reader.Release()
+	reader.Debug = &debug
+
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, head, debug.String())
+	assert.Equal(t, "hello", readBody(t, r))
+}
+
+func TestReaderGrowsTwice(t *testing.T) {
+	// A header line of more than twice the buffer size grows it twice.
+	value := strings.Repeat("a", 3*bufferSize)
+	reader := NewReader(&chunkReader{data: "GET / HTTP/1.1\r\nHost: localhost\r\nX-Long: " + value + "\r\n\r\n", numBytesPerRead: bufferSize})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	got, _ := r.Headers.Get("x-long")
+	assert.Equal(t, value, got)
+	reader.Release()
+
+	// Test: The pool only hands out usable buffers
+	for range 8 {
+		assert.NotPanics(t, func() { NewReader(strings.NewReader("")) })
+	}
+}
+
+func TestHeaderSemantics(t *testing.T) {
+	testCases := []struct {
+		name           string
+		request        string
+		expectedErr    error
+		expectedLength string
+	}{
+		{
+			name:        "Duplicate Host",
+			request:     "GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n",
+			expectedErr: ERROR_DUPLICATE_HOST,
+		},
+		{
+			name:        "Conflicting Content-Length lines",
+			request:     "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!",
+			expectedErr: ERROR_CONFLICTING_CONTENT_LENGTH,
+		},
+		{
+			name:        "Conflicting Content-Length list",
+			request:     "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5, 6\r\n\r\nhello!",
+			expectedErr: ERROR_CONFLICTING_CONTENT_LENGTH,
+		},
+		{
+			name:        "Invalid Content-Length",
+			request:     "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5x\r\n\r\nhello",
+			expectedErr: headers.ERROR_INVALID_CONTENT_LENGTH,
+		},
+		{
+			name:           "Identical Content-Length values are collapsed",
+			request:        "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\ncontent-length: 5, 5\r\n\r\nhello",
+			expectedLength: "5",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RequestFromReader(&chunkReader{data: tc.request, numBytesPerRead: 5})
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []string{tc.expectedLength}, r.Headers.Values("content-length"))
+			assert.Equal(t, "hello", readBody(t, r))
+		})
+	}
+}
+
+func TestHost(t *testing.T) {
+	testCases := []struct {
+		name        string
+		request     string
+		expectedErr error
+		host        string
+	}{
+		{name: "Name", request: "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", host: "example.com"},
+		{name: "Name and port", request: "GET / HTTP/1.1\r\nHost: example.com:8080\r\n\r\n", host: "example.com:8080"},
+		{name: "IPv4", request: "GET / HTTP/1.1\r\nHost: 127.0.0.1:42069\r\n\r\n", host: "127.0.0.1:42069"},
+		{name: "IPv6", request: "GET / HTTP/1.1\r\nHost: [::1]:42069\r\n\r\n", host: "[::1]:42069"},
+		{name: "Absolute form overrides Host", request: "GET http://a.example/ HTTP/1.1\r\nHost: b.example\r\n\r\n", host: "a.example"},
+		{name: "Optional for HTTP/1.0", request: "GET / HTTP/1.0\r\n\r\n", host: ""},
+		{name: "Missing", request: "GET / HTTP/1.1\r\nAccept: */*\r\n\r\n", expectedErr: ERROR_MISSING_HOST},
+		{name: "Empty", request: "GET / HTTP/1.1\r\nHost: \r\n\r\n", expectedErr: ERROR_INVALID_HOST},
+		{name: "Userinfo", request: "GET / HTTP/1.1\r\nHost: user@example.com\r\n\r\n", expectedErr: ERROR_INVALID_HOST},
+		{name: "Path", request: "GET / HTTP/1.1\r\nHost: example.com/x\r\n\r\n", expectedErr: ERROR_INVALID_HOST},
+		{name: "Space", request: "GET / HTTP/1.1\r\nHost: exa mple.com\r\n\r\n", expectedErr: ERROR_INVALID_HOST},
+		{name: "Bad port", request: "GET / HTTP/1.1\r\nHost: example.com:80a\r\n\r\n", expectedErr: ERROR_INVALID_HOST},
+		{name: "Unclosed IPv6", request: "GET / HTTP/1.1\r\nHost: [::1\r\n\r\n", expectedErr: ERROR_INVALID_HOST},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RequestFromReader(&chunkReader{data: tc.request, numBytesPerRead: 4})
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.host, r.Host)
+		})
+	}
+}
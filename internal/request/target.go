@@ -0,0 +1,222 @@
+package request
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TargetForm is one of the four request-target forms of RFC 9112.
+type TargetForm string
+
+const (
+	// FormOrigin is "/path?query", used by most requests.
+	FormOrigin TargetForm = "origin"
+	// FormAbsolute is "http://host/path?query", sent to proxies.
+	FormAbsolute TargetForm = "absolute"
+	// FormAuthority is "host:port", only used by CONNECT.
+	FormAuthority TargetForm = "authority"
+	// FormAsterisk is "*", only used by server-wide OPTIONS.
+	FormAsterisk TargetForm = "asterisk"
+)
+
+var ERROR_BAD_TARGET = fmt.Errorf("malformed request-target")
+
+// Target is the parsed request-target.
+type Target struct {
+	Form TargetForm
+	// Scheme is the lowercased scheme of an absolute-form target.
+	Scheme string
+	// Authority is the host[:port] of an absolute- or authority-form
+	// target. For origin-form targets the authority is in the Host header.
+	Authority string
+	// Path is the percent-decoded path with "." and ".." segments
+	// resolved. It always starts with "/" for origin and absolute forms.
+	Path string
+	// RawPath is the path as sent, without the query.
+	RawPath string
+	// RawQuery is the query as sent, without the "?".
+	RawQuery string
+	// Query holds the decoded query parameters. Malformed pairs are
+	// skipped.
+	Query url.Values
+}
+
+// parseTarget classifies and parses target. The authority form is required
+// for CONNECT and not allowed for anything else; the asterisk form only for
+// OPTIONS.
+func parseTarget(method string, target string) (Target, error) {
+	if method == "CONNECT" {
+		return parseAuthorityForm(target)
+	}
+
+	switch {
+	case target == "*":
+		if method != "OPTIONS" {
+			return Target{}, ERROR_BAD_TARGET
+		}
+		return Target{Form: FormAsterisk}, nil
+	case strings.HasPrefix(target, "/"):
+		t := Target{Form: FormOrigin}
+		return t, t.parsePathQuery(target)
+	}
+
+	scheme, rest, ok := strings.Cut(target, "://")
+	if !ok || !validScheme(scheme) {
+		return Target{}, ERROR_BAD_TARGET
+	}
+	t := Target{Form: FormAbsolute, Scheme: strings.ToLower(scheme)}
+	end := strings.IndexAny(rest, "/?")
+	if end == -1 {
+		end = len(rest)
+	}
+	t.Authority = rest[:end]
+	if !validAuthority(t.Authority) {
+		return Target{}, ERROR_BAD_TARGET
+	}
+	pathQuery := rest[end:]
+	if !strings.HasPrefix(pathQuery, "/") {
+		// "http://host" and "http://host?q" have an empty path, which
+		// means "/".
+		pathQuery = "/" + pathQuery
+	}
+	return t, t.parsePathQuery(pathQuery)
+}
+
+func parseAuthorityForm(target string) (Target, error) {
+	host, port, ok := strings.Cut(target, ":")
+	if !ok || host == "" || port == "" || strings.Trim(port, "0123456789") != "" || !validAuthority(target) {
+		return Target{}, ERROR_BAD_TARGET
+	}
+	return Target{Form: FormAuthority, Authority: target}, nil
+}
+
+func validScheme(scheme string) bool {
+	if scheme == "" || !isAlpha(scheme[0]) {
+		return false
+	}
+	for i := 1; i < len(scheme); i++ {
+		c := scheme[i]
+		if !isAlpha(c) && !isDigit(c) && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// validAuthority rejects empty authorities and ones carrying userinfo,
+// which RFC 9110 forbids in http(s) targets, or anything else that isn't a
+// host[:port].
+func validAuthority(authority string) bool {
+	return validHost(authority)
+}
+
+// validHost reports whether s is a non-empty host[:port] as in RFC 9110: a
+// registered name or IPv4 address, or an IPv6 literal in brackets, and an
+// optional numeric port.
+func validHost(s string) bool {
+	host, port := s, ""
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return false
+		}
+		host, port = s[1:end], s[end+1:]
+		if host == "" || strings.Trim(host, "0123456789abcdefABCDEF:.") != "" {
+			return false
+		}
+	} else {
+		if i := strings.IndexByte(s, ':'); i >= 0 {
+			host, port = s[:i], s[i:]
+		}
+		if host == "" {
+			return false
+		}
+		for i := 0; i < len(host); i++ {
+			if !isRegNameChar(host[i]) {
+				return false
+			}
+		}
+	}
+	if port != "" && (port[0] != ':' || strings.Trim(port[1:], "0123456789") != "") {
+		return false
+	}
+	return true
+}
+
+// isRegNameChar reports whether c may appear in a reg-name: unreserved,
+// sub-delims and the "%" of percent-encoding.
+func isRegNameChar(c byte) bool {
+	return isAlpha(c) || isDigit(c) || strings.IndexByte("-._~!$&'()*+,;=%", c) >= 0
+}
+
+// isPathQueryChar reports whether c may appear in a path or query: pchar,
+// "/" and "?".
+func isPathQueryChar(c byte) bool {
+	return isRegNameChar(c) || c == ':' || c == '@' || c == '/' || c == '?'
+}
+
+// parsePathQuery fills in the path and query fields from an origin-form
+// target. Only the characters of RFC 3986's path and query may appear;
+// whitespace, control bytes and fragments are all refused.
+func (t *Target) parsePathQuery(target string) error {
+	for i := 0; i < len(target); i++ {
+		if !isPathQueryChar(target[i]) {
+			return ERROR_BAD_TARGET
+		}
+	}
+	t.RawPath, t.RawQuery, _ = strings.Cut(target, "?")
+	if t.RawQuery != "" {
+		// ParseQuery keeps the pairs it could decode.
+		t.Query, _ = url.ParseQuery(t.RawQuery)
+	}
+
+	path, err := cleanPath(t.RawPath)
+	if err != nil {
+		return err
+	}
+	t.Path = path
+	return nil
+}
+
+// cleanPath percent-decodes rawPath and then removes its dot segments (RFC
+// 3986 section 5.2.4), never climbing above the root. Decoding comes first so
+// that encoded dots and slashes, as in "/..%2F..%2Fetc", can't smuggle a dot
+// segment past the cleanup; an encoded "%2F" is therefore a separator like
+// any other "/".
+func cleanPath(rawPath string) (string, error) {
+	if !strings.Contains(rawPath, "%") && !strings.Contains(rawPath, "/.") {
+		// Fast path: nothing to decode or resolve.
+		return rawPath, nil
+	}
+
+	decoded, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return "", ERROR_BAD_TARGET
+	}
+	segments := strings.Split(decoded[1:], "/")
+	out := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, segment)
+		}
+	}
+	return "/" + strings.Join(out, "/"), nil
+}
@@ -4,48 +4,21 @@ import (
 	"fmt"
 	"github.com/t3nna/http-from-tcp/internal/headers"
 	"io"
-)
-
-type StatusCode int
-
-const (
-	StatusOK                  StatusCode = 200
-	StatusBarRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
+	"strings"
 )
 
 const rn = "\r\n"
 
-func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
-	statusLine := []byte("")
-	switch statusCode {
-	case StatusOK:
-		statusLine = []byte("HTTP/1.1 200 OK")
-	case StatusBarRequest:
-		statusLine = []byte("HTTP/1.1 400 Bad Request")
-
-	case StatusInternalServerError:
-		statusLine = []byte("HTTP/1.1 500 Internal Server Error")
-
-	default:
-		return fmt.Errorf("unknow status code")
-	}
-
-	statusLine = append(statusLine, []byte(rn)...)
-
-	_, err := w.Write(statusLine)
-	return err
-
-}
-
 func GetDefaultHeaders(contentLen int) *headers.Headers {
 	h := headers.NewHeaders()
-	h.Replace("content-length", fmt.Sprintf("%d", contentLen))
-	h.Set("connection", "close")
-	h.Set("content-type", "text/plain")
+	h.Set("Content-Length", fmt.Sprintf("%d", contentLen))
+	h.Set("Content-Type", "text/plain")
 	return h
 }
 func WriteHeaders(w io.Writer, h *headers.Headers) error {
+	if err := validFields(h); err != nil {
+		return err
+	}
 	var headersLine []byte
 	h.ForEach(func(key, value string) {
 		headersLine = fmt.Appendf(headersLine, "%s: %s%s", key, value, rn)
@@ -55,47 +28,390 @@ func WriteHeaders(w io.Writer, h *headers.Headers) error {
 	return err
 }
 
+// validFields refuses fields whose name or value would break out of their
+// line, so that a value taken from a request can't smuggle in a header of
+// its own.
+func validFields(h *headers.Headers) error {
+	var err error
+	h.ForEach(func(name, value string) {
+		if err == nil && (strings.ContainsAny(name, "\r\n\x00: ") || strings.ContainsAny(value, "\r\n\x00")) {
+			err = fmt.Errorf("invalid header field %q", name)
+		}
+	})
+	return err
+}
+
+// The protocol versions a Writer can answer with.
+const (
+	Version10 = "1.0"
+	Version11 = "1.1"
+)
+
+var ERROR_NOT_CHUNKED = fmt.Errorf("response is not using chunked transfer-encoding")
+var ERROR_UNSUPPORTED_VERSION = fmt.Errorf("unsupported response version")
+var ERROR_CHUNKED_BODY_DONE = fmt.Errorf("chunked body already finished")
+var ERROR_TRAILERS_WRITTEN = fmt.Errorf("trailers already written")
+
+type writerState string
+
+const (
+	WriterStateStatusLine writerState = "status-line"
+	WriterStateHeaders    writerState = "headers"
+	WriterStateBody       writerState = "body"
+	WriterStateTrailers   writerState = "trailers"
+	WriterStateDone       writerState = "done"
+)
+
+// WriterStateError is returned when a Writer method is called out of order,
+// e.g. WriteBody before WriteHeaders or WriteStatusLine twice. Nothing is
+// written to the connection in that case.
+type WriterStateError struct {
+	Op    string
+	State writerState
+}
+
+func (e *WriterStateError) Error() string {
+	return fmt.Sprintf("response: %s not allowed in %s state", e.Op, e.State)
+}
+
 type Writer struct {
-	writer io.Writer
+	writer     io.Writer
+	state      writerState
+	version    string
+	closeAfter bool
+	// omitBody is set for responses to HEAD, which never have a body.
+	omitBody bool
+
+	chunked          bool
+	trailersWritten  bool
+	declaredTrailers map[string]bool
+
+	statusCode    StatusCode
+	bytesWritten  int64
+	beforeHeaders []func(statusCode StatusCode, h *headers.Headers)
 }
 
 func NewWriter(conn io.Writer) *Writer {
-	return &Writer{writer: conn}
+	return &Writer{
+		writer:  conn,
+		state:   WriterStateStatusLine,
+		version: Version11,
+	}
 }
 
-func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	statusLine := []byte("")
-	switch statusCode {
-	case StatusOK:
-		statusLine = []byte("HTTP/1.1 200 OK")
-	case StatusBarRequest:
-		statusLine = []byte("HTTP/1.1 400 Bad Request")
+func (w *Writer) expect(op string, state writerState) error {
+	if w.state != state {
+		return &WriterStateError{Op: op, State: w.state}
+	}
+	return nil
+}
+
+// Written reports whether anything of the response has been sent yet. Once
+// it has, the status can no longer be changed.
+func (w *Writer) Written() bool {
+	return w.state != WriterStateStatusLine
+}
+
+// StatusCode returns the status sent with the status line, or 0 if none was
+// written yet.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten returns the number of body bytes sent so far, not counting
+// chunk framing.
+func (w *Writer) BytesWritten() int64 {
+	return w.bytesWritten
+}
+
+// BeforeHeaders registers fn to run right before the headers are written,
+// letting middleware inspect the status and add or change fields. Hooks run
+// in registration order.
+func (w *Writer) BeforeHeaders(fn func(statusCode StatusCode, h *headers.Headers)) {
+	w.beforeHeaders = append(w.beforeHeaders, fn)
+}
 
-	case StatusInternalServerError:
-		statusLine = []byte("HTTP/1.1 500 Internal Server Error")
+// SetVersion picks the HTTP version of the response, Version11 unless set.
+// An HTTP/1.0 response can't use chunked framing: a chunked body is sent as
+// is and ends with the connection, and trailers are dropped. Unless
+// CloseConnection is called, it announces "Connection: keep-alive".
+func (w *Writer) SetVersion(version string) error {
+	if err := w.expect("SetVersion", WriterStateStatusLine); err != nil {
+		return err
+	}
+	if version != Version10 && version != Version11 {
+		return ERROR_UNSUPPORTED_VERSION
+	}
+	w.version = version
+	return nil
+}
 
-	default:
-		return fmt.Errorf("unknow status code")
+// OmitBody marks the response as the answer to a HEAD request. It carries
+// the headers a GET would get but no body, so leaving out Content-Length
+// doesn't mean the connection has to close. Body writes are still counted by
+// BytesWritten but nothing of them reaches the connection, so handlers can
+// answer HEAD like GET.
+func (w *Writer) OmitBody() {
+	w.omitBody = true
+}
+
+// hasBody reports whether the response may carry a body: 1xx, 204 and 304
+// responses and answers to HEAD never do.
+func (w *Writer) hasBody() bool {
+	if w.omitBody {
+		return false
+	}
+	switch {
+	case w.statusCode < 200, w.statusCode == StatusNoContent, w.statusCode == StatusNotModified:
+		return false
 	}
+	return true
+}
 
-	statusLine = append(statusLine, []byte(rn)...)
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteCustomStatusLine(statusCode, StatusText(statusCode))
+}
 
-	_, err := w.writer.Write(statusLine)
-	return err
+// WriteCustomStatusLine is WriteStatusLine with a caller-supplied reason
+// phrase.
+func (w *Writer) WriteCustomStatusLine(statusCode StatusCode, reason string) error {
+	if err := w.expect("WriteStatusLine", WriterStateStatusLine); err != nil {
+		return err
+	}
+	if err := validStatusLine(statusCode, reason); err != nil {
+		return err
+	}
 
+	w.state = WriterStateHeaders
+	w.statusCode = statusCode
+	return writeStatusLine(w.writer, w.version, statusCode, reason)
 }
+
+// CloseConnection marks the connection to be closed once this response is
+// done. WriteHeaders will then announce it with "Connection: close".
+func (w *Writer) CloseConnection() {
+	w.closeAfter = true
+}
+
+// ShouldClose reports whether the connection can't be reused after this
+// response, either because the server or the handler asked for it, because
+// the body has no framing the client could use to find its end, or because
+// the response was left unfinished.
+func (w *Writer) ShouldClose() bool {
+	switch w.state {
+	case WriterStateStatusLine, WriterStateHeaders, WriterStateTrailers:
+		return true
+	case WriterStateBody:
+		if w.chunked && w.version != Version10 && !w.omitBody {
+			// The client is still waiting for the end of the chunked body.
+			return true
+		}
+	}
+	return w.closeAfter
+}
+
 func (w *Writer) WriteHeaders(h *headers.Headers) error {
+	if err := w.expect("WriteHeaders", WriterStateHeaders); err != nil {
+		return err
+	}
+	for _, fn := range w.beforeHeaders {
+		fn(w.statusCode, h)
+	}
+	if err := validFields(h); err != nil {
+		return err
+	}
+
+	if w.version == Version10 && h.HasToken("transfer-encoding", "chunked") {
+		// HTTP/1.0 has no chunked framing; the body is delimited by
+		// closing the connection instead.
+		h.Delete("Transfer-Encoding")
+		h.Delete("Trailer")
+		w.closeAfter = true
+		w.chunked = true
+	}
+	if h.HasToken("connection", "close") {
+		w.closeAfter = true
+	}
+	_, hasLength := h.Get("content-length")
+	_, hasEncoding := h.Get("transfer-encoding")
+	if !hasLength && !hasEncoding && w.hasBody() {
+		// Without framing the body ends when the connection does.
+		w.closeAfter = true
+	}
+	if w.closeAfter {
+		h.Set("Connection", "close")
+	} else if w.version == Version10 {
+		h.Set("Connection", "keep-alive")
+	}
+	w.chunked = w.chunked || h.HasToken("transfer-encoding", "chunked")
+	if trailer, ok := h.Get("trailer"); ok {
+		w.declaredTrailers = map[string]bool{}
+		for _, name := range strings.Split(trailer, ",") {
+			w.declaredTrailers[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+
 	var headersLine []byte
 	h.ForEach(func(key, value string) {
 		headersLine = fmt.Appendf(headersLine, "%s: %s%s", key, value, rn)
 	})
 	headersLine = fmt.Append(headersLine, rn)
+
+	w.state = WriterStateBody
 	_, err := w.writer.Write(headersLine)
 	return err
 
 }
 func (w *Writer) WriteBody(p []byte) (int, error) {
+	if err := w.expect("WriteBody", WriterStateBody); err != nil {
+		return 0, err
+	}
+	if w.omitBody {
+		w.bytesWritten += int64(len(p))
+		return len(p), nil
+	}
 	n, err := w.writer.Write(p)
+	w.bytesWritten += int64(n)
 	return n, err
 
 }
+
+// WriteChunkedBody sends p as a single chunk. The headers must have declared
+// "Transfer-Encoding: chunked". Empty writes are skipped, since a zero-size
+// chunk would end the body.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if !w.chunked {
+		return 0, ERROR_NOT_CHUNKED
+	}
+	if w.state == WriterStateDone || w.state == WriterStateTrailers {
+		return 0, ERROR_CHUNKED_BODY_DONE
+	}
+	if err := w.expect("WriteChunkedBody", WriterStateBody); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.version == Version10 || w.omitBody {
+		return w.WriteBody(p)
+	}
+
+	chunk := fmt.Appendf(nil, "%x%s", len(p), rn)
+	chunk = append(chunk, p...)
+	chunk = append(chunk, rn...)
+	if _, err := w.writer.Write(chunk); err != nil {
+		return 0, err
+	}
+	w.bytesWritten += int64(len(p))
+	return len(p), nil
+}
+
+// WriteChunkedBodyDone writes the last chunk. When the headers declared a
+// Trailer, the message stays open until WriteTrailers, otherwise it is
+// terminated right away.
+func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if !w.chunked {
+		return 0, ERROR_NOT_CHUNKED
+	}
+	if w.state == WriterStateDone || w.state == WriterStateTrailers {
+		return 0, ERROR_CHUNKED_BODY_DONE
+	}
+	if err := w.expect("WriteChunkedBodyDone", WriterStateBody); err != nil {
+		return 0, err
+	}
+
+	if w.version == Version10 || w.omitBody {
+		w.state = WriterStateDone
+		return 0, nil
+	}
+
+	end := "0" + rn
+	if len(w.declaredTrailers) == 0 {
+		end += rn
+		w.state = WriterStateDone
+	} else {
+		w.state = WriterStateTrailers
+	}
+	return w.writer.Write([]byte(end))
+}
+
+// WriteTrailers sends the trailer fields and terminates the chunked body,
+// writing the last chunk first if WriteChunkedBodyDone wasn't called. Every
+// field must have been announced in the Trailer header.
+func (w *Writer) WriteTrailers(h *headers.Headers) error {
+	if !w.chunked {
+		return ERROR_NOT_CHUNKED
+	}
+	if w.trailersWritten {
+		return ERROR_TRAILERS_WRITTEN
+	}
+	if w.state == WriterStateDone {
+		// The last chunk already terminated the message.
+		return ERROR_CHUNKED_BODY_DONE
+	}
+	if w.state != WriterStateBody && w.state != WriterStateTrailers {
+		return &WriterStateError{Op: "WriteTrailers", State: w.state}
+	}
+	if w.version == Version10 || w.omitBody {
+		// There is nowhere to put trailers without chunked framing, or
+		// without a body.
+		w.trailersWritten = true
+		w.state = WriterStateDone
+		return nil
+	}
+
+	var undeclared []string
+	h.ForEach(func(name, value string) {
+		if !w.declaredTrailers[strings.ToLower(name)] {
+			undeclared = append(undeclared, name)
+		}
+	})
+	if len(undeclared) > 0 {
+		return fmt.Errorf("trailer not declared in Trailer header: %s", strings.Join(undeclared, ", "))
+	}
+	if err := validFields(h); err != nil {
+		return err
+	}
+
+	var trailers []byte
+	if w.state == WriterStateBody {
+		trailers = append(trailers, "0"+rn...)
+	}
+	h.ForEach(func(key, value string) {
+		trailers = fmt.Appendf(trailers, "%s: %s%s", key, value, rn)
+	})
+	trailers = append(trailers, rn...)
+	w.trailersWritten = true
+	w.state = WriterStateDone
+
+	_, err := w.writer.Write(trailers)
+	return err
+}
+
+// Finish completes whatever the handler left open. A handler that wrote
+// nothing gets an implicit 200 with default headers, a status line without
+// headers gets the default headers, and an unterminated chunked body gets
+// its last chunk and (empty) trailers.
+func (w *Writer) Finish() error {
+	switch w.state {
+	case WriterStateStatusLine:
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return err
+		}
+		return w.WriteHeaders(GetDefaultHeaders(0))
+	case WriterStateHeaders:
+		return w.WriteHeaders(GetDefaultHeaders(0))
+	case WriterStateBody:
+		if !w.chunked {
+			return nil
+		}
+		if len(w.declaredTrailers) > 0 {
+			return w.WriteTrailers(headers.NewHeaders())
+		}
+		_, err := w.WriteChunkedBodyDone()
+		return err
+	case WriterStateTrailers:
+		return w.WriteTrailers(headers.NewHeaders())
+	}
+	return nil
+}
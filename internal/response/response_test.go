@@ -0,0 +1,346 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t3nna/http-from-tcp/internal/headers"
+)
+
+func chunkedHeaders(trailers ...string) *headers.Headers {
+	h := headers.NewHeaders()
+	h.Set("transfer-encoding", "chunked")
+	for _, name := range trailers {
+		h.Add("Trailer", name)
+	}
+	return h
+}
+
+func TestWriteChunkedBody(t *testing.T) {
+	// Test: Chunks without trailers
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders()))
+	buf.Reset()
+
+	n, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	n, err = w.WriteChunkedBody([]byte(" world, this is sixteen+"))
+	require.NoError(t, err)
+	assert.Equal(t, 24, n)
+	n, err = w.WriteChunkedBody(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.Equal(t, "5\r\nhello\r\n18\r\n world, this is sixteen+\r\n0\r\n\r\n", buf.String())
+	assert.False(t, w.ShouldClose())
+
+	_, err = w.WriteChunkedBody([]byte("late"))
+	assert.ErrorIs(t, err, ERROR_CHUNKED_BODY_DONE)
+	assert.ErrorIs(t, w.WriteTrailers(headers.NewHeaders()), ERROR_CHUNKED_BODY_DONE)
+
+	// Test: Declared trailers
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Checksum")))
+	buf.Reset()
+
+	_, err = w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.True(t, w.ShouldClose(), "trailers are still pending")
+
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "1234")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "3\r\nabc\r\n0\r\nX-Checksum: 1234\r\n\r\n", buf.String())
+	assert.False(t, w.ShouldClose())
+	assert.ErrorIs(t, w.WriteTrailers(trailers), ERROR_TRAILERS_WRITTEN)
+
+	// Test: WriteTrailers ends the body on its own
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Checksum")))
+	buf.Reset()
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "0\r\nX-Checksum: 1234\r\n\r\n", buf.String())
+}
+
+func TestWriteChunkedBodyErrors(t *testing.T) {
+	// Test: Not a chunked response
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	assert.ErrorIs(t, err, ERROR_NOT_CHUNKED)
+	_, err = w.WriteChunkedBodyDone()
+	assert.ErrorIs(t, err, ERROR_NOT_CHUNKED)
+
+	// Test: Undeclared trailer
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Checksum")))
+	buf.Reset()
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "1234")
+	trailers.Set("X-Secret", "nope")
+	require.Error(t, w.WriteTrailers(trailers))
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestWriterOrdering(t *testing.T) {
+	// Test: Body before status line
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	_, err := w.WriteBody([]byte("hello"))
+	var stateErr *WriterStateError
+	require.ErrorAs(t, err, &stateErr)
+	assert.Equal(t, "WriteBody", stateErr.Op)
+	assert.Equal(t, WriterStateStatusLine, stateErr.State)
+	assert.ErrorAs(t, w.WriteHeaders(GetDefaultHeaders(0)), &stateErr)
+	assert.Equal(t, 0, buf.Len())
+	assert.False(t, w.Written())
+
+	// Test: Status line and headers twice
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.True(t, w.Written())
+	assert.ErrorAs(t, w.WriteStatusLine(StatusOK), &stateErr)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	assert.ErrorAs(t, w.WriteHeaders(GetDefaultHeaders(5)), &stateErr)
+	_, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("HTTP/1.1 200 OK\r\n")))
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nhello")))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	assert.ErrorIs(t, err, ERROR_NOT_CHUNKED)
+}
+
+func TestWriterFinish(t *testing.T) {
+	// Test: Nothing written gets an implicit 200
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.Finish())
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("HTTP/1.1 200 OK\r\n")))
+	assert.Contains(t, buf.String(), "Content-Length: 0\r\n")
+	assert.False(t, w.ShouldClose())
+
+	// Test: Unterminated chunked body
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders()))
+	_, err := w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, err)
+	assert.True(t, w.ShouldClose())
+	require.NoError(t, w.Finish())
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("2\r\nhi\r\n0\r\n\r\n")))
+	assert.False(t, w.ShouldClose())
+}
+
+func TestWriteStatusLine(t *testing.T) {
+	testCases := []struct {
+		name     string
+		code     StatusCode
+		reason   string
+		custom   bool
+		expected string
+		wantErr  bool
+	}{
+		{name: "OK", code: StatusOK, expected: "HTTP/1.1 200 OK\r\n"},
+		{name: "No Content", code: StatusNoContent, expected: "HTTP/1.1 204 No Content\r\n"},
+		{name: "Moved Permanently", code: StatusMovedPermanently, expected: "HTTP/1.1 301 Moved Permanently\r\n"},
+		{name: "Not Modified", code: StatusNotModified, expected: "HTTP/1.1 304 Not Modified\r\n"},
+		{name: "Not Found", code: StatusNotFound, expected: "HTTP/1.1 404 Not Found\r\n"},
+		{name: "Content Too Large", code: StatusContentTooLarge, expected: "HTTP/1.1 413 Content Too Large\r\n"},
+		{name: "Service Unavailable", code: StatusServiceUnavailable, expected: "HTTP/1.1 503 Service Unavailable\r\n"},
+		{name: "Unregistered code", code: 299, expected: "HTTP/1.1 299 \r\n"},
+		{name: "Custom reason", code: 299, reason: "Mostly Fine", custom: true, expected: "HTTP/1.1 299 Mostly Fine\r\n"},
+		{name: "Custom reason on known code", code: StatusOK, reason: "Alright", custom: true, expected: "HTTP/1.1 200 Alright\r\n"},
+		{name: "Code out of range", code: 42, wantErr: true},
+		{name: "Reason with CRLF", code: StatusOK, reason: "OK\r\nX-Injected: 1", custom: true, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var err error
+			if tc.custom {
+				err = WriteCustomStatusLine(&buf, tc.code, tc.reason)
+			} else {
+				err = WriteStatusLine(&buf, tc.code)
+			}
+
+			var wbuf bytes.Buffer
+			w := NewWriter(&wbuf)
+			var werr error
+			if tc.custom {
+				werr = w.WriteCustomStatusLine(tc.code, tc.reason)
+			} else {
+				werr = w.WriteStatusLine(tc.code)
+			}
+
+			if tc.wantErr {
+				assert.Error(t, err)
+				assert.Error(t, werr)
+				assert.False(t, w.Written())
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, werr)
+			assert.Equal(t, tc.expected, buf.String())
+			assert.Equal(t, tc.expected, wbuf.String())
+		})
+	}
+}
+
+func TestWriteHeadersOrder(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	h := GetDefaultHeaders(0)
+	h.Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT")
+	h.Add("Set-Cookie", "b=2")
+	h.Set("Content-Type", "text/html")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 0\r\n"+
+		"Content-Type: text/html\r\n"+
+		"Set-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\n"+
+		"Set-Cookie: b=2\r\n"+
+		"\r\n", buf.String())
+}
+
+func TestWriteHeadersInvalidFields(t *testing.T) {
+	testCases := []struct {
+		name  string
+		field string
+		value string
+	}{
+		{name: "LF in value", field: "Location", value: "/dir/?a\nSet-Cookie: evil=1"},
+		{name: "CR in value", field: "Location", value: "/dir/\r"},
+		{name: "NUL in value", field: "X-Name", value: "a\x00b"},
+		{name: "Colon in name", field: "X-A: b", value: "c"},
+		{name: "LF in name", field: "X-A\nX-B", value: "c"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := GetDefaultHeaders(0)
+			h.Set(tc.field, tc.value)
+
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			require.NoError(t, w.WriteStatusLine(StatusOK))
+			assert.Error(t, w.WriteHeaders(h))
+			assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+
+			buf.Reset()
+			assert.Error(t, WriteHeaders(&buf, h))
+			assert.Equal(t, 0, buf.Len())
+		})
+	}
+}
+
+func TestHTTP10Writer(t *testing.T) {
+	// Test: Chunked body is sent unframed and closes the connection
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.SetVersion(Version10))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Checksum")))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "1234")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nConnection: close\r\n\r\nhello", buf.String())
+	assert.True(t, w.ShouldClose())
+
+	// Test: Keep-alive is announced for framed bodies
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.SetVersion(Version10))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nContent-Length: 0\r\nContent-Type: text/plain\r\nConnection: keep-alive\r\n\r\n", buf.String())
+	assert.False(t, w.ShouldClose())
+
+	// Test: Version can't change once the status line is out
+	assert.ErrorIs(t, NewWriter(&buf).SetVersion("2.0"), ERROR_UNSUPPORTED_VERSION)
+	var stateErr *WriterStateError
+	assert.ErrorAs(t, w.SetVersion(Version11), &stateErr)
+}
+
+func TestOmitBody(t *testing.T) {
+	// Test: Body bytes are counted but not sent
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.OmitBody()
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, int64(5), w.BytesWritten())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\n", buf.String())
+	assert.False(t, w.ShouldClose())
+
+	// Test: Chunks, the last chunk and trailers are dropped too
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.OmitBody()
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Checksum")))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.False(t, w.ShouldClose())
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "1234")
+	require.NoError(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"), buf.String())
+	assert.NotContains(t, buf.String(), "hello")
+	assert.NotContains(t, buf.String(), "1234")
+	assert.False(t, w.ShouldClose())
+}
+
+func TestUnframedResponses(t *testing.T) {
+	testCases := []struct {
+		name        string
+		code        StatusCode
+		head        bool
+		expectClose bool
+	}{
+		{name: "200 without length closes", code: StatusOK, expectClose: true},
+		{name: "204 has no body", code: StatusNoContent},
+		{name: "304 has no body", code: StatusNotModified},
+		{name: "1xx has no body", code: StatusCode(103)},
+		{name: "HEAD has no body", code: StatusOK, head: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			if tc.head {
+				w.OmitBody()
+			}
+			require.NoError(t, w.WriteStatusLine(tc.code))
+			require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+			assert.Equal(t, tc.expectClose, w.ShouldClose())
+			assert.Equal(t, tc.expectClose, strings.Contains(buf.String(), "Connection: close\r\n"), buf.String())
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/t3nna/http-from-tcp/internal/request"
+)
+
+const (
+	// DefaultReadHeaderTimeout is how long a client gets to send a request
+	// line and headers once it has started a request.
+	DefaultReadHeaderTimeout = 10 * time.Second
+	// DefaultReadTimeout is how long a client gets to send a whole request,
+	// body included, once it has started it.
+	DefaultReadTimeout = 30 * time.Second
+	// DefaultIdleTimeout is how long a keep-alive connection may sit
+	// between requests before the server closes it.
+	DefaultIdleTimeout = 60 * time.Second
+	// DefaultMaxRequestsPerConn caps how many requests are served on a
+	// single connection before the server asks the client to reconnect.
+	DefaultMaxRequestsPerConn = 100
+)
+
+// Config tunes connection handling. A zero duration or count disables the
+// corresponding limit; DefaultConfig has sensible values for all of them.
+type Config struct {
+	// ReadHeaderTimeout bounds the time from the first byte of a request
+	// to the end of its headers. It protects against slowloris clients
+	// that trickle headers to hold connections open. If zero, ReadTimeout
+	// applies.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds the time from the first byte of a request to the
+	// end of its body.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the time from the end of the request headers to
+	// the end of the response.
+	WriteTimeout time.Duration
+	// IdleTimeout bounds the wait for the next request on a keep-alive
+	// connection.
+	IdleTimeout time.Duration
+	// RequestTimeout is the deadline of each request's context.
+	RequestTimeout time.Duration
+	// MaxRequestsPerConn caps the requests served on one connection.
+	MaxRequestsPerConn int
+	// Limits caps the size of each request. Exceeding them is answered
+	// with 414, 431 or 413.
+	Limits request.Limits
+	// Logger receives the server's own messages: failed and timed out
+	// requests, handler panics and, at debug level, the raw head of every
+	// request. If nil, slog.Default is used.
+	Logger *slog.Logger
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ReadHeaderTimeout:  DefaultReadHeaderTimeout,
+		ReadTimeout:        DefaultReadTimeout,
+		IdleTimeout:        DefaultIdleTimeout,
+		MaxRequestsPerConn: DefaultMaxRequestsPerConn,
+		Limits:             request.DefaultLimits(),
+	}
+}
+
+// deadline returns start+d, or the zero time (no deadline) when d is zero.
+func deadline(start time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return start.Add(d)
+}
+
+// headerDeadline is when a request started at start must have sent its
+// headers: the earlier of the header and the whole-request limits.
+func (c Config) headerDeadline(start time.Time) time.Time {
+	headers := deadline(start, c.ReadHeaderTimeout)
+	whole := deadline(start, c.ReadTimeout)
+	if headers.IsZero() || (!whole.IsZero() && whole.Before(headers)) {
+		return whole
+	}
+	return headers
+}
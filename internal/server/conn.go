@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past, used to interrupt a blocked read.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// connReader sits between a connection and the request parser. While a
+// handler runs it keeps one read pending on the connection so that a client
+// hanging up is noticed; a byte that read returns instead belongs to the
+// next pipelined request and is handed back to the parser.
+type connReader struct {
+	conn io.ReadWriteCloser
+
+	mu         sync.Mutex
+	pending    []byte
+	err        error
+	background chan struct{}
+
+	// onData, if set, is called once the next time a read returns data.
+	onData func()
+}
+
+// notifyOnData arms fn to run when the next byte of a request arrives.
+func (cr *connReader) notifyOnData(fn func()) {
+	cr.onData = fn
+}
+
+func (cr *connReader) dataArrived() {
+	if cr.onData != nil {
+		fn := cr.onData
+		cr.onData = nil
+		fn()
+	}
+}
+
+func newConnReader(conn io.ReadWriteCloser) *connReader {
+	return &connReader{conn: conn}
+}
+
+func (cr *connReader) Read(p []byte) (int, error) {
+	cr.mu.Lock()
+	if len(cr.pending) > 0 {
+		n := copy(p, cr.pending)
+		cr.pending = cr.pending[n:]
+		cr.mu.Unlock()
+		cr.dataArrived()
+		return n, nil
+	}
+	if cr.err != nil {
+		err := cr.err
+		cr.mu.Unlock()
+		return 0, err
+	}
+	cr.mu.Unlock()
+
+	n, err := cr.conn.Read(p)
+	if n > 0 {
+		cr.dataArrived()
+	}
+	return n, err
+}
+
+// startBackgroundRead watches the connection until abortPendingRead, calling
+// onClose if the client goes away. It needs read deadlines to be
+// interruptible, so it does nothing on connections without them.
+func (cr *connReader) startBackgroundRead(onClose context.CancelFunc) {
+	c, ok := cr.conn.(deadliner)
+	if !ok || len(cr.pending) > 0 || cr.err != nil {
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+
+	done := make(chan struct{})
+	cr.background = done
+	go func() {
+		defer close(done)
+
+		buf := make([]byte, 1)
+		n, err := cr.conn.Read(buf)
+
+		cr.mu.Lock()
+		defer cr.mu.Unlock()
+		cr.pending = append(cr.pending, buf[:n]...)
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			cr.err = err
+			onClose()
+		}
+	}()
+}
+
+// abortPendingRead stops the background read and waits for it to return.
+func (cr *connReader) abortPendingRead() {
+	if cr.background == nil {
+		return
+	}
+	cr.conn.(deadliner).SetReadDeadline(aLongTimeAgo)
+	<-cr.background
+	cr.background = nil
+}
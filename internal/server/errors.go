@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+
+	"github.com/t3nna/http-from-tcp/internal/headers"
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+)
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("%d %s", e.StatusCode, e.Message)
+}
+
+// NewHandlerError builds a HandlerError, defaulting the message to the
+// status code's reason phrase.
+func NewHandlerError(statusCode response.StatusCode, message string) *HandlerError {
+	if message == "" {
+		message = response.StatusText(statusCode)
+	}
+	return &HandlerError{StatusCode: statusCode, Message: message}
+}
+
+// ErrorHandler is a Handler that can fail. Returning a *HandlerError sends
+// its status and message to the client; any other error becomes a generic
+// 500 and is only logged.
+type ErrorHandler func(w *response.Writer, req *request.Request) error
+
+// HandleErrors adapts h to a Handler, rendering the error it returns.
+func HandleErrors(h ErrorHandler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if err := h(w, req); err != nil {
+			WriteError(w, req, err)
+		}
+	}
+}
+
+// WriteError renders err as a response in whichever of plain text, HTML or
+// JSON the client prefers. A body over the size limit, which a chunked one
+// only reveals while it is read, is a 413. Errors other than *HandlerError
+// map to 500 with the standard reason phrase so internals don't leak. If the
+// handler already started its response, the connection is closed instead.
+func WriteError(w *response.Writer, req *request.Request, err error) {
+	var herr *HandlerError
+	if errors.Is(err, request.ERROR_BODY_TOO_LARGE) {
+		// The rest of the body isn't worth reading.
+		w.CloseConnection()
+		herr = NewHandlerError(response.StatusContentTooLarge, "")
+	} else if !errors.As(err, &herr) {
+		LoggerFromContext(req.Context()).Error("error serving request", "error", err)
+		herr = NewHandlerError(response.StatusInternalServerError, "")
+	}
+
+	if w.Written() {
+		w.CloseConnection()
+		return
+	}
+	writeHandlerError(w, req, herr, response.GetDefaultHeaders(0))
+}
+
+const (
+	contentTypeText = "text/plain"
+	contentTypeHTML = "text/html"
+	contentTypeJSON = "application/json"
+)
+
+func writeHandlerError(w *response.Writer, req *request.Request, herr *HandlerError, h *headers.Headers) {
+	message := herr.Message
+	if message == "" {
+		message = response.StatusText(herr.StatusCode)
+	}
+
+	contentType := negotiate(req.Headers.Accept(), contentTypeText, contentTypeHTML, contentTypeJSON)
+
+	var body []byte
+	switch contentType {
+	case contentTypeHTML:
+		title := html.EscapeString(fmt.Sprintf("%d %s", herr.StatusCode, response.StatusText(herr.StatusCode)))
+		body = fmt.Appendf(nil, "<html>\n  <head>\n    <title>%s</title>\n  </head>\n  <body>\n    <h1>%s</h1>\n    <p>%s</p>\n  </body>\n</html>\n",
+			title, title, html.EscapeString(message))
+	case contentTypeJSON:
+		body, _ = json.Marshal(struct {
+			Status  int    `json:"status"`
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}{int(herr.StatusCode), response.StatusText(herr.StatusCode), message})
+		body = append(body, '\n')
+	default:
+		body = fmt.Appendf(nil, "%d %s\n", herr.StatusCode, message)
+	}
+
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	w.WriteStatusLine(herr.StatusCode)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method != "HEAD" {
+		// HEAD gets the page's length and type but not the page itself.
+		w.WriteBody(body)
+	}
+}
+
+// writeStatus sends the standard error page for statusCode.
+func writeStatus(w *response.Writer, req *request.Request, statusCode response.StatusCode, h *headers.Headers) {
+	writeHandlerError(w, req, NewHandlerError(statusCode, ""), h)
+}
+
+// negotiate picks the offer the Accept header rates highest, preferring
+// earlier offers on ties. The first offer is the fallback when nothing is
+// acceptable or no Accept header was sent.
+func negotiate(accept []headers.MediaRange, offers ...string) string {
+	best := offers[0]
+	bestQ := 0.0
+	for _, offer := range offers {
+		q := acceptQuality(accept, offer)
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value the Accept header gives mediaType, using
+// the most specific matching range.
+func acceptQuality(accept []headers.MediaRange, mediaType string) float64 {
+	if len(accept) == 0 {
+		return 1
+	}
+
+	q, specificity := 0.0, -1
+	for _, m := range accept {
+		if s := m.Specificity(mediaType); s > specificity {
+			q, specificity = m.Q, s
+		}
+	}
+	return q
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+)
+
+func TestHandleErrors(t *testing.T) {
+	notFound := HandleErrors(func(w *response.Writer, req *request.Request) error {
+		return NewHandlerError(response.StatusNotFound, "no such <user>")
+	})
+	internal := HandleErrors(func(w *response.Writer, req *request.Request) error {
+		return fmt.Errorf("db password is hunter2")
+	})
+	wrapped := HandleErrors(func(w *response.Writer, req *request.Request) error {
+		return fmt.Errorf("loading: %w", NewHandlerError(response.StatusForbidden, ""))
+	})
+
+	testCases := []struct {
+		name        string
+		handler     Handler
+		accept      string
+		status      string
+		contentType string
+		body        string
+	}{
+		{
+			name:        "Plain text by default",
+			handler:     notFound,
+			status:      "404 Not Found",
+			contentType: "text/plain",
+			body:        "404 no such <user>\n",
+		},
+		{
+			name:        "HTML escapes the message",
+			handler:     notFound,
+			accept:      "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			status:      "404 Not Found",
+			contentType: "text/html",
+			body:        "<p>no such &lt;user&gt;</p>",
+		},
+		{
+			name:        "JSON",
+			handler:     notFound,
+			accept:      "application/json",
+			status:      "404 Not Found",
+			contentType: "application/json",
+			body:        `{"status":404,"error":"Not Found","message":"no such \u003cuser\u003e"}` + "\n",
+		},
+		{
+			name:        "Weighted preference",
+			handler:     notFound,
+			accept:      "text/html;q=0.5, application/json;q=0.9",
+			status:      "404 Not Found",
+			contentType: "application/json",
+		},
+		{
+			name:        "Nothing acceptable falls back to text",
+			handler:     notFound,
+			accept:      "image/png",
+			status:      "404 Not Found",
+			contentType: "text/plain",
+		},
+		{
+			name:        "Unexpected errors don't leak",
+			handler:     internal,
+			status:      "500 Internal Server Error",
+			contentType: "text/plain",
+			body:        "500 Internal Server Error\n",
+		},
+		{
+			name:        "Wrapped HandlerError",
+			handler:     wrapped,
+			status:      "403 Forbidden",
+			contentType: "text/plain",
+			body:        "403 Forbidden\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+			if tc.accept != "" {
+				raw += "Accept: " + tc.accept + "\r\n"
+			}
+			out := serveRequest(t, tc.handler, raw+"\r\n")
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 "+tc.status+"\r\n"), out)
+			assert.Contains(t, out, "Content-Type: "+tc.contentType+"\r\n")
+			assert.NotContains(t, out, "hunter2")
+			if tc.body != "" {
+				assert.Contains(t, out, tc.body)
+			}
+		})
+	}
+}
+
+func TestErrorPageForHEAD(t *testing.T) {
+	handler := HandleErrors(func(w *response.Writer, req *request.Request) error {
+		return NewHandlerError(response.StatusNotFound, "")
+	})
+	out := serveRequest(t, handler, "HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\nContent-Length: 14\r\nContent-Type: text/plain\r\n\r\n", out)
+}
+
+func TestChunkedBodyTooLarge(t *testing.T) {
+	handler := HandleErrors(func(w *response.Writer, req *request.Request) error {
+		_, err := io.ReadAll(req.Body)
+		return err
+	})
+	config := DefaultConfig()
+	config.Limits.MaxBodyBytes = 4
+	conn := newFakeConn("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n0\r\n\r\n", 1024)
+	runConnections(newServer(config, handler), conn)
+
+	out := conn.out.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"), out)
+	assert.Contains(t, out, "Connection: close\r\n")
+}
+
+func TestHandleErrorsAfterWrite(t *testing.T) {
+	handler := HandleErrors(func(w *response.Writer, req *request.Request) error {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		return errors.New("too late")
+	})
+	out := serveRequest(t, handler, simpleRequest)
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/t3nna/http-from-tcp/internal/headers"
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+)
+
+// sniffLen is how much of a file is inspected to guess its type when the
+// extension doesn't tell.
+const sniffLen = 512
+
+// indexPage is served for a directory that has one.
+const indexPage = "index.html"
+
+// DirFS opens dir as a file system for FileServer and ServeFile. Lookups are
+// confined to dir: "..", absolute paths and symlinks that lead outside it
+// all fail. The directory stays open for the life of the process.
+func DirFS(dir string) (fs.FS, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return root.FS(), nil
+}
+
+// FileServer answers GET and HEAD requests with files from an fs.FS, such
+// as one from DirFS or an embed.FS. The request path, minus Prefix, names the
+// file. A directory is served through its index.html if it has one, and
+// otherwise listed if ListDirectories is set.
+type FileServer struct {
+	fsys fs.FS
+
+	// Prefix is removed from the request path before the file is looked
+	// up, for a server mounted at e.g. "/static/*". Requests outside it
+	// get a 404.
+	Prefix string
+	// ListDirectories renders an HTML listing of directories without an
+	// index.html. They get a 404 otherwise.
+	ListDirectories bool
+}
+
+func NewFileServer(fsys fs.FS) *FileServer {
+	return &FileServer{fsys: fsys}
+}
+
+// Handler returns the file server as a server.Handler.
+func (fsrv *FileServer) Handler() Handler {
+	return fsrv.serve
+}
+
+func (fsrv *FileServer) serve(w *response.Writer, req *request.Request) {
+	if !allowFileMethod(w, req) {
+		return
+	}
+
+	// Target.Path is already decoded and free of dot segments.
+	urlPath := req.Target.Path
+	rest, ok := strings.CutPrefix(urlPath, strings.TrimSuffix(fsrv.Prefix, "/"))
+	if !ok || (rest != "" && rest[0] != '/') {
+		writeStatus(w, req, response.StatusNotFound, response.GetDefaultHeaders(0))
+		return
+	}
+	name := strings.Trim(rest, "/")
+	if name == "" {
+		name = "."
+	}
+	if !validFileName(name) {
+		writeStatus(w, req, response.StatusNotFound, response.GetDefaultHeaders(0))
+		return
+	}
+
+	info, err := fs.Stat(fsrv.fsys, name)
+	if err != nil {
+		writeFileError(w, req, err)
+		return
+	}
+	if !info.IsDir() {
+		if strings.HasSuffix(rest, "/") {
+			writeStatus(w, req, response.StatusNotFound, response.GetDefaultHeaders(0))
+			return
+		}
+		serveFile(w, req, fsrv.fsys, name)
+		return
+	}
+
+	if !strings.HasSuffix(urlPath, "/") {
+		// Relative links in the index or listing need the slash. The
+		// location comes from the cleaned path with its leading slashes
+		// collapsed, since "//host/" would send the client off-site.
+		location := (&url.URL{Path: "/" + strings.TrimLeft(urlPath, "/") + "/"}).EscapedPath()
+		if req.Target.RawQuery != "" {
+			// The query passed the parser's URI character check, and the
+			// Writer refuses line breaks in any case.
+			location += "?" + req.Target.RawQuery
+		}
+		h := response.GetDefaultHeaders(0)
+		h.Set("Location", location)
+		writeStatus(w, req, response.StatusMovedPermanently, h)
+		return
+	}
+	index := path.Join(name, indexPage)
+	if info, err := fs.Stat(fsrv.fsys, index); err == nil && !info.IsDir() {
+		serveFile(w, req, fsrv.fsys, index)
+		return
+	}
+	if !fsrv.ListDirectories {
+		writeStatus(w, req, response.StatusNotFound, response.GetDefaultHeaders(0))
+		return
+	}
+	fsrv.list(w, req, name)
+}
+
+// list answers with an HTML page linking to the entries of directory name.
+func (fsrv *FileServer) list(w *response.Writer, req *request.Request, name string) {
+	entries, err := fs.ReadDir(fsrv.fsys, name)
+	if err != nil {
+		writeFileError(w, req, err)
+		return
+	}
+
+	title := html.EscapeString("Index of " + req.Target.Path)
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "<html>\n  <head>\n    <title>%s</title>\n  </head>\n  <body>\n    <h1>%s</h1>\n    <ul>\n", title, title)
+	if req.Target.Path != "/" {
+		body.WriteString("      <li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		// "./" keeps a name like "a:b" from reading as a scheme.
+		href := "./" + url.PathEscape(entry.Name())
+		if entry.IsDir() {
+			href += "/"
+		}
+		fmt.Fprintf(&body, "      <li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(entryName))
+	}
+	body.WriteString("    </ul>\n  </body>\n</html>\n")
+
+	h := response.GetDefaultHeaders(body.Len())
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody(body.Bytes())
+	}
+}
+
+// ServeFile answers a GET or HEAD request with the regular file name from
+// fsys, which is an fs.FS path such as "assets/vim.mp4". Directories get a
+// 404.
+func ServeFile(w *response.Writer, req *request.Request, fsys fs.FS, name string) {
+	if !allowFileMethod(w, req) {
+		return
+	}
+	if !validFileName(name) {
+		writeStatus(w, req, response.StatusNotFound, response.GetDefaultHeaders(0))
+		return
+	}
+	serveFile(w, req, fsys, name)
+}
+
+// serveFile streams the file name with its length, type and modification
+// time, or the parts of it a Range header asks for.
+func serveFile(w *response.Writer, req *request.Request, fsys fs.FS, name string) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		writeFileError(w, req, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeFileError(w, req, err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		writeStatus(w, req, response.StatusNotFound, response.GetDefaultHeaders(0))
+		return
+	}
+
+	var content io.Reader = f
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		sniff := make([]byte, sniffLen)
+		n, err := io.ReadFull(f, sniff)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			WriteError(w, req, err)
+			return
+		}
+		contentType = http.DetectContentType(sniff[:n])
+		content = io.MultiReader(bytes.NewReader(sniff[:n]), f)
+	}
+
+	size := info.Size()
+	h := response.GetDefaultHeaders(0)
+	h.Set("Content-Length", strconv.FormatInt(size, 10))
+	h.Set("Content-Type", contentType)
+	if modTime := info.ModTime(); !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.UTC().Format(headers.TimeFormat))
+	}
+
+	// Ranges need to seek, which every fs.FS in the standard library
+	// supports; others always get the whole file.
+	if seeker, ok := f.(io.ReadSeeker); ok {
+		h.Set("Accept-Ranges", "bytes")
+		ranges, ok := requestedRanges(req, size, info.ModTime())
+		if !ok {
+			h := response.GetDefaultHeaders(0)
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			writeStatus(w, req, response.StatusRangeNotSatisfiable, h)
+			return
+		}
+		if len(ranges) > 0 {
+			if err := writeRanges(w, seeker, size, contentType, ranges, h); err != nil {
+				LoggerFromContext(req.Context()).Error("serving file", "name", name, "error", err)
+				w.CloseConnection()
+			}
+			return
+		}
+	}
+
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+
+	// Never send more than Content-Length promised, and close the
+	// connection if the file ends early so the client notices.
+	if _, err := io.CopyN(bodyWriter{w}, content, size); err != nil {
+		LoggerFromContext(req.Context()).Error("serving file", "name", name, "error", err)
+		w.CloseConnection()
+	}
+}
+
+// allowFileMethod answers anything but GET and HEAD with a 405 and reports
+// whether the request may go on.
+func allowFileMethod(w *response.Writer, req *request.Request) bool {
+	switch req.RequestLine.Method {
+	case "GET", "HEAD":
+		return true
+	}
+	h := response.GetDefaultHeaders(0)
+	h.Set("Allow", "GET, HEAD")
+	writeStatus(w, req, response.StatusMethodNotAllowed, h)
+	return false
+}
+
+// validFileName reports whether name is an fs.FS path the file server may
+// open. Backslashes are refused too so that no platform reads them as
+// separators.
+func validFileName(name string) bool {
+	return fs.ValidPath(name) && !strings.ContainsAny(name, "\\\x00")
+}
+
+// writeFileError answers a failed lookup. Anything but a permission problem
+// is a 404, including a symlink leading out of the root, so clients learn
+// nothing about what lies outside it.
+func writeFileError(w *response.Writer, req *request.Request, err error) {
+	if errors.Is(err, fs.ErrPermission) {
+		writeStatus(w, req, response.StatusForbidden, response.GetDefaultHeaders(0))
+		return
+	}
+	LoggerFromContext(req.Context()).Debug("file not found", "error", err)
+	writeStatus(w, req, response.StatusNotFound, response.GetDefaultHeaders(0))
+}
+
+// bodyWriter adapts a Writer's body to io.Writer.
+type bodyWriter struct {
+	w *response.Writer
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	return b.w.WriteBody(p)
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+)
+
+var testFiles = fstest.MapFS{
+	"hello.txt":           {Data: []byte("hello, world\n"), ModTime: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+	"page":                {Data: []byte("<!DOCTYPE html><title>x</title>")},
+	"logo.png":            {Data: []byte("\x89PNG\r\n\x1a\nrest")},
+	"docs/index.html":     {Data: []byte("<h1>docs</h1>")},
+	"files/a.txt":         {Data: []byte("a")},
+	"files/sub/b.txt":     {Data: []byte("b")},
+	"files/<odd> & a:b":   {Data: []byte("odd")},
+	"files/sub/more/c.md": {Data: []byte("c")},
+}
+
+func TestFileServer(t *testing.T) {
+	fsrv := NewFileServer(testFiles)
+	fsrv.ListDirectories = true
+
+	testCases := []struct {
+		name     string
+		request  string
+		status   string
+		body     string
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "File",
+			request:  "GET /hello.txt HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:   "200 OK",
+			body:     "hello, world\n",
+			contains: []string{"Content-Length: 13\r\n", "Content-Type: text/plain; charset=utf-8\r\n", "Last-Modified: Fri, 01 Mar 2024 12:00:00 GMT\r\n"},
+		},
+		{
+			name:     "Type by extension",
+			request:  "GET /docs/index.html HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:   "200 OK",
+			contains: []string{"Content-Type: text/html; charset=utf-8\r\n"},
+		},
+		{
+			name:     "Type by sniffing",
+			request:  "GET /page HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:   "200 OK",
+			body:     "<!DOCTYPE html><title>x</title>",
+			contains: []string{"Content-Type: text/html; charset=utf-8\r\n"},
+		},
+		{
+			name:     "HEAD has no body",
+			request:  "HEAD /hello.txt HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:   "200 OK",
+			contains: []string{"Content-Length: 13\r\n"},
+			excludes: []string{"hello, world"},
+		},
+		{
+			name:    "Index page",
+			request: "GET /docs/ HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:  "200 OK",
+			body:    "<h1>docs</h1>",
+		},
+		{
+			name:     "Directory without slash redirects",
+			request:  "GET /docs?x=1 HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:   "301 Moved Permanently",
+			contains: []string{"Location: /docs/?x=1\r\n"},
+		},
+		{
+			name:     "Redirect stays on this host",
+			request:  "GET //docs HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:   "301 Moved Permanently",
+			contains: []string{"Location: /docs/\r\n"},
+		},
+		{
+			name:     "Redirect uses the cleaned path",
+			request:  "GET /files/sub/../../%64ocs HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:   "301 Moved Permanently",
+			contains: []string{"Location: /docs/\r\n"},
+		},
+		{
+			name:    "Listing",
+			request: "GET /files/ HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:  "200 OK",
+			contains: []string{
+				"<title>Index of /files/</title>",
+				`<li><a href="../">../</a></li>`,
+				`<li><a href="./a.txt">a.txt</a></li>`,
+				`<li><a href="./sub/">sub/</a></li>`,
+				`<li><a href="./%3Codd%3E%20&amp;%20a:b">&lt;odd&gt; &amp; a:b</a></li>`,
+			},
+		},
+		{name: "Missing file", request: "GET /nope.txt HTTP/1.1\r\nHost: localhost\r\n\r\n", status: "404 Not Found"},
+		{name: "File with trailing slash", request: "GET /hello.txt/ HTTP/1.1\r\nHost: localhost\r\n\r\n", status: "404 Not Found"},
+		{name: "Traversal stays in root", request: "GET /../../hello.txt HTTP/1.1\r\nHost: localhost\r\n\r\n", status: "200 OK", body: "hello, world\n"},
+		{name: "Encoded traversal stays in root", request: "GET /files/%2E%2E/%2e%2e/hello.txt HTTP/1.1\r\nHost: localhost\r\n\r\n", status: "200 OK", body: "hello, world\n"},
+		{name: "Backslash", request: "GET /files%5C..%5Chello.txt HTTP/1.1\r\nHost: localhost\r\n\r\n", status: "404 Not Found"},
+		{name: "Wrong method", request: "POST /hello.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n", status: "405 Method Not Allowed", contains: []string{"Allow: GET, HEAD\r\n"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := serveRequest(t, fsrv.Handler(), tc.request)
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 "+tc.status+"\r\n"), out)
+			if tc.body != "" {
+				assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+tc.body), out)
+			}
+			for _, s := range tc.contains {
+				assert.Contains(t, out, s)
+			}
+			for _, s := range tc.excludes {
+				assert.NotContains(t, out, s)
+			}
+		})
+	}
+}
+
+func TestFileServerOptions(t *testing.T) {
+	// Test: Listing is off by default
+	out := serveRequest(t, NewFileServer(testFiles).Handler(), "GET /files/ HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), out)
+
+	// Test: Prefix is stripped
+	router := NewRouter()
+	fsrv := NewFileServer(testFiles)
+	fsrv.Prefix = "/static/"
+	router.Handle("GET", "/static/*", fsrv.Handler())
+	out = serveRequest(t, router.Handler(), "GET /static/files/a.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\na"), out)
+
+	// Test: Requests outside the prefix
+	out = serveRequest(t, fsrv.Handler(), "GET /staticfiles/a.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), out)
+}
+
+func TestFileServerRedirectQuery(t *testing.T) {
+	// A line break in the query would otherwise end up in Location.
+	conn := newFakeConn("GET /docs?a\nSet-Cookie:\tevil=1 HTTP/1.1\r\nHost: localhost\r\n\r\n", 1024)
+	runConnections(newTestServer(NewFileServer(testFiles).Handler()), conn)
+	out := conn.out.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"), out)
+	assert.NotContains(t, out, "Set-Cookie")
+}
+
+func TestServeFile(t *testing.T) {
+	serve := func(name string) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			ServeFile(w, req, testFiles, name)
+		}
+	}
+
+	out := serveRequest(t, serve("logo.png"), "GET /logo HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, out, "Content-Type: image/png\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n\x89PNG\r\n\x1a\nrest"), out)
+
+	// Test: Directories aren't served
+	out = serveRequest(t, serve("docs"), "GET /docs HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), out)
+
+	// Test: Names are fs.FS paths
+	out = serveRequest(t, serve("../hello.txt"), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), out)
+}
+
+func TestDirFS(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "public.txt"), []byte("public"), 0o644))
+	require.NoError(t, os.Symlink("public.txt", filepath.Join(root, "inside.txt")))
+	require.NoError(t, os.Symlink("../secret.txt", filepath.Join(root, "escape.txt")))
+	require.NoError(t, os.Symlink(dir, filepath.Join(root, "parent")))
+
+	fsys, err := DirFS(root)
+	require.NoError(t, err)
+	fsrv := NewFileServer(fsys)
+	fsrv.ListDirectories = true
+
+	testCases := []struct {
+		name   string
+		target string
+		status string
+		body   string
+	}{
+		{name: "File", target: "/public.txt", status: "200 OK", body: "public"},
+		{name: "Symlink inside the root", target: "/inside.txt", status: "200 OK", body: "public"},
+		{name: "Symlink out of the root", target: "/escape.txt", status: "404 Not Found"},
+		{name: "Through a symlinked directory", target: "/parent/secret.txt", status: "404 Not Found"},
+		{name: "Dot segments", target: "/../secret.txt", status: "404 Not Found"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := serveRequest(t, fsrv.Handler(), "GET "+tc.target+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 "+tc.status+"\r\n"), out)
+			if tc.body != "" {
+				assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+tc.body), out)
+			}
+			assert.False(t, strings.HasSuffix(out, "secret"), out)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+)
+
+type loggerKey struct{}
+
+// LoggerFromContext returns the logger of the request owning ctx. The server
+// sets one carrying the remote address, method and target of the request;
+// without it slog.Default is returned.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.config.Logger != nil {
+		return s.config.Logger
+	}
+	return slog.Default()
+}
+
+// remoteAddr returns the client address of conn, or "" for connections that
+// don't have one.
+func remoteAddr(conn io.ReadWriteCloser) string {
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok && c.RemoteAddr() != nil {
+		return c.RemoteAddr().String()
+	}
+	return ""
+}
+
+// debugWriter passes the raw request heads dumped by the parser to the
+// logger at debug level.
+type debugWriter struct {
+	logger *slog.Logger
+}
+
+func (d debugWriter) Write(p []byte) (int, error) {
+	d.logger.Debug("request head", "raw", string(p))
+	return len(p), nil
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/t3nna/http-from-tcp/internal/headers"
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+)
+
+// Middleware wraps a Handler with behaviour that runs around it.
+type Middleware func(Handler) Handler
+
+// Chain wraps h in middleware. The first middleware is the outermost: it
+// sees the request first and the finished response last.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Logging logs one record per request at info level, with the remote
+// address, method, target, status, duration and body bytes sent once the
+// handler is done. A handler that panics is logged too, with the 500 it
+// will be answered with, and the panic carries on to Recover.
+func Logging(logger *slog.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			defer func() {
+				rec := recover()
+				status := FinishedStatus(w, rec)
+				logger.LogAttrs(req.Context(), slog.LevelInfo, "request",
+					slog.String("remote_addr", req.RemoteAddr),
+					slog.String("method", req.RequestLine.Method),
+					slog.String("target", req.RequestLine.RequestTarget),
+					slog.Int("status", int(status)),
+					slog.Duration("duration", time.Since(start)),
+					slog.Int64("bytes", w.BytesWritten()),
+				)
+				if rec != nil {
+					panic(rec)
+				}
+			}()
+			next(w, req)
+		}
+	}
+}
+
+// FinishedStatus returns the status a logging middleware should record for
+// a handler that returned, or panicked with rec if it isn't nil. A returning
+// handler's response is completed first so the implicit 200 shows; a
+// panicking one that wrote nothing will be answered with a 500.
+func FinishedStatus(w *response.Writer, rec any) response.StatusCode {
+	if rec == nil {
+		w.Finish()
+	} else if !w.Written() {
+		return response.StatusInternalServerError
+	}
+	return w.StatusCode()
+}
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID the RequestID middleware assigned to
+// the request owning ctx.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestID makes sure every request carries an X-Request-ID header, keeping
+// the client's one if it sent one, and echoes it in the response. The ID is
+// also stored in the request context.
+func RequestID() Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id, ok := req.Headers.Get(requestIDHeader)
+			if !ok || id == "" {
+				id = newRequestID()
+				req.Headers.Set(requestIDHeader, id)
+			}
+			w.BeforeHeaders(func(statusCode response.StatusCode, h *headers.Headers) {
+				h.Set(requestIDHeader, id)
+			})
+			next(w, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+		}
+	}
+}
+
+// Recover turns a handler panic into a 500 if nothing was written yet. If the
+// response was already under way the connection is closed instead, since the
+// client can't tell a truncated body from a complete one.
+func Recover(logger *slog.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				logger.Error("panic serving request",
+					"method", req.RequestLine.Method,
+					"target", req.RequestLine.RequestTarget,
+					"panic", rec,
+				)
+				w.CloseConnection()
+				if !w.Written() {
+					writeStatus(w, req, response.StatusInternalServerError, response.GetDefaultHeaders(0))
+				}
+			}()
+			next(w, req)
+		}
+	}
+}
+
+// Timing reports how long the handler took to produce its headers in a
+// Server-Timing header.
+func Timing() Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			w.BeforeHeaders(func(statusCode response.StatusCode, h *headers.Headers) {
+				elapsed := float64(time.Since(start).Microseconds()) / 1000
+				h.Set("Server-Timing", fmt.Sprintf("app;dur=%.3f", elapsed))
+			})
+			next(w, req)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+)
+
+const simpleRequest = "GET /hello HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	mark := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) {
+				calls = append(calls, name+" in")
+				next(w, req)
+				calls = append(calls, name+" out")
+			}
+		}
+	}
+
+	handler := Chain(routeTo("ok"), mark("outer"), mark("inner"))
+	serveRequest(t, handler, simpleRequest)
+	assert.Equal(t, []string{"outer in", "inner in", "inner out", "outer out"}, calls)
+}
+
+// newTestLogger returns a logger writing text records without timestamps to
+// buf.
+func newTestLogger(buf *bytes.Buffer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "duration" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newTestLogger(&buf, slog.LevelInfo)
+
+	serveRequest(t, Chain(routeTo("hello"), Logging(logger)), simpleRequest)
+	assert.Equal(t, "level=INFO msg=request remote_addr=\"\" method=GET target=/hello status=200 bytes=5\n", buf.String())
+
+	// Test: Implicit response is logged as sent
+	buf.Reset()
+	out := serveRequest(t, Chain(func(w *response.Writer, req *request.Request) {}, Logging(logger)), simpleRequest)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, buf.String(), "status=200 bytes=0", buf.String())
+
+	// Test: Panic is logged with the 500 it's answered with
+	buf.Reset()
+	out = serveRequest(t, Chain(func(w *response.Writer, req *request.Request) { panic("boom") },
+		Recover(newTestLogger(&bytes.Buffer{}, slog.LevelInfo)), Logging(logger)), simpleRequest)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"), out)
+	assert.Equal(t, "level=INFO msg=request remote_addr=\"\" method=GET target=/hello status=500 bytes=0\n", buf.String())
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	var fromContext string
+	handler := Chain(func(w *response.Writer, req *request.Request) {
+		seen, _ = req.Headers.Get("x-request-id")
+		fromContext, _ = RequestIDFromContext(req.Context())
+		routeTo("ok")(w, req)
+	}, RequestID())
+
+	// Test: Generated ID
+	out := serveRequest(t, handler, simpleRequest)
+	require.Len(t, seen, 32)
+	assert.Equal(t, seen, fromContext)
+	assert.Contains(t, strings.ToLower(out), "x-request-id: "+seen+"\r\n")
+
+	// Test: Client supplied ID is kept
+	out = serveRequest(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: abc-123\r\n\r\n")
+	assert.Equal(t, "abc-123", seen)
+	assert.Contains(t, strings.ToLower(out), "x-request-id: abc-123\r\n")
+}
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newTestLogger(&buf, slog.LevelInfo)
+
+	// Test: Panic before writing
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	req, err := request.RequestFromReader(strings.NewReader(simpleRequest))
+	require.NoError(t, err)
+	Chain(func(w *response.Writer, req *request.Request) { panic("boom") }, Recover(logger))(w, req)
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.True(t, w.ShouldClose())
+	assert.Contains(t, buf.String(), "msg=\"panic serving request\" method=GET target=/hello panic=boom")
+
+	// Test: Panic mid-body only closes the connection
+	out.Reset()
+	w = response.NewWriter(&out)
+	Chain(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(10))
+		w.WriteBody([]byte("half"))
+		panic("boom")
+	}, Recover(logger))(w, req)
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, w.ShouldClose())
+}
+
+func TestTiming(t *testing.T) {
+	out := serveRequest(t, Chain(routeTo("ok"), Timing()), simpleRequest)
+	assert.Regexp(t, `(?i)server-timing: app;dur=\d+\.\d{3}\r\n`, out)
+}
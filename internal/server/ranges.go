@@ -0,0 +1,153 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/t3nna/http-from-tcp/internal/headers"
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+)
+
+// byteRange is a satisfiable range of a representation, resolved against
+// its size.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// requestedRanges returns the parts of a size-byte representation a GET
+// asks for with Range. It returns no ranges, meaning the whole
+// representation, if there is no Range, it is malformed, If-Range doesn't
+// match lastModified, or the ranges add up to more than the whole. ok is
+// false if none of the ranges overlaps the representation, which is a 416.
+func requestedRanges(req *request.Request, size int64, lastModified time.Time) (ranges []byteRange, ok bool) {
+	if req.RequestLine.Method != "GET" {
+		return nil, true
+	}
+	specs, err := req.Headers.Range()
+	if err != nil || specs == nil {
+		// A Range we can't parse is ignored (RFC 9110, 14.2).
+		return nil, true
+	}
+	if !ifRangeMatches(req, lastModified) {
+		return nil, true
+	}
+
+	var total int64
+	for _, spec := range specs {
+		var r byteRange
+		switch {
+		case spec.Start == -1:
+			// The last End bytes.
+			if spec.End == 0 || size == 0 {
+				continue
+			}
+			r.length = min(spec.End, size)
+			r.start = size - r.length
+		case spec.Start >= size:
+			continue
+		default:
+			end := spec.End
+			if end == -1 || end >= size {
+				end = size - 1
+			}
+			r.start = spec.Start
+			r.length = end - spec.Start + 1
+		}
+		ranges = append(ranges, r)
+		total += r.length
+	}
+	if len(ranges) == 0 {
+		return nil, false
+	}
+	if total > size {
+		// Overlapping or repeated ranges would cost more than sending
+		// the whole thing once.
+		return nil, true
+	}
+	return ranges, true
+}
+
+// ifRangeMatches reports whether the representation is still the one an
+// If-Range names, so that a partial response can be sent. Files have no
+// entity tag, so only a date equal to lastModified matches.
+func ifRangeMatches(req *request.Request, lastModified time.Time) bool {
+	etag, date, ok := req.Headers.IfRange()
+	if !ok {
+		_, present := req.Headers.Get("if-range")
+		return !present
+	}
+	if etag != "" || lastModified.IsZero() {
+		return false
+	}
+	return date.Equal(lastModified.Truncate(time.Second))
+}
+
+// writeRanges answers with the ranges of content, a size-byte
+// representation of contentType: a plain 206 for a single range, a
+// multipart/byteranges body for several. h holds the headers of the whole
+// representation; its framing and type are replaced.
+func writeRanges(w *response.Writer, content io.ReadSeeker, size int64, contentType string, ranges []byteRange, h *headers.Headers) error {
+	if len(ranges) == 1 {
+		r := ranges[0]
+		h.Set("Content-Length", strconv.FormatInt(r.length, 10))
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Range", r.contentRange(size))
+		w.WriteStatusLine(response.StatusPartialContent)
+		w.WriteHeaders(h)
+		return copyRange(w, content, r)
+	}
+
+	// Every part's header is known up front, so the body needn't be
+	// chunked.
+	boundary := newBoundary()
+	parts := make([]string, len(ranges))
+	length := int64(0)
+	for i, r := range ranges {
+		parts[i] = fmt.Sprintf("--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", boundary, contentType, r.contentRange(size))
+		length += int64(len(parts[i])) + r.length + 2
+	}
+	closing := "--" + boundary + "--\r\n"
+	length += int64(len(closing))
+
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	w.WriteStatusLine(response.StatusPartialContent)
+	w.WriteHeaders(h)
+	for i, r := range ranges {
+		if _, err := w.WriteBody([]byte(parts[i])); err != nil {
+			return err
+		}
+		if err := copyRange(w, content, r); err != nil {
+			return err
+		}
+		if _, err := w.WriteBody([]byte("\r\n")); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteBody([]byte(closing))
+	return err
+}
+
+func copyRange(w *response.Writer, content io.ReadSeeker, r byteRange) error {
+	if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.CopyN(bodyWriter{w}, content, r.length)
+	return err
+}
+
+func newBoundary() string {
+	b := make([]byte, 15)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
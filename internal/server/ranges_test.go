@@ -0,0 +1,103 @@
+package server
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRanges(t *testing.T) {
+	fsrv := NewFileServer(testFiles)
+
+	// hello.txt is "hello, world\n", 13 bytes, last modified at this time.
+	const modified = "Fri, 01 Mar 2024 12:00:00 GMT"
+
+	testCases := []struct {
+		name     string
+		method   string
+		headers  string
+		status   string
+		body     string
+		contains []string
+	}{
+		{name: "No range", headers: "", status: "200 OK", body: "hello, world\n", contains: []string{"Accept-Ranges: bytes\r\n"}},
+		{name: "Range", headers: "Range: bytes=0-4\r\n", status: "206 Partial Content", body: "hello", contains: []string{"Content-Range: bytes 0-4/13\r\n", "Content-Length: 5\r\n", "Content-Type: text/plain; charset=utf-8\r\n"}},
+		{name: "Open range", headers: "Range: bytes=7-\r\n", status: "206 Partial Content", body: "world\n", contains: []string{"Content-Range: bytes 7-12/13\r\n"}},
+		{name: "Suffix range", headers: "Range: bytes=-6\r\n", status: "206 Partial Content", body: "world\n", contains: []string{"Content-Range: bytes 7-12/13\r\n"}},
+		{name: "Suffix longer than file", headers: "Range: bytes=-100\r\n", status: "206 Partial Content", body: "hello, world\n", contains: []string{"Content-Range: bytes 0-12/13\r\n"}},
+		{name: "End past the file", headers: "Range: bytes=10-100\r\n", status: "206 Partial Content", body: "ld\n", contains: []string{"Content-Range: bytes 10-12/13\r\n"}},
+		{name: "Unsatisfiable ranges are dropped", headers: "Range: bytes=50-60, 0-0\r\n", status: "206 Partial Content", body: "h", contains: []string{"Content-Range: bytes 0-0/13\r\n"}},
+		{name: "Unsatisfiable", headers: "Range: bytes=13-\r\n", status: "416 Range Not Satisfiable", contains: []string{"Content-Range: bytes */13\r\n"}},
+		{name: "Empty suffix", headers: "Range: bytes=-0\r\n", status: "416 Range Not Satisfiable"},
+		{name: "Malformed range is ignored", headers: "Range: bytes=4-2\r\n", status: "200 OK", body: "hello, world\n"},
+		{name: "Other units are ignored", headers: "Range: lines=1-2\r\n", status: "200 OK", body: "hello, world\n"},
+		{name: "More than the whole file", headers: "Range: bytes=0-10, 2-12\r\n", status: "200 OK", body: "hello, world\n"},
+		{name: "HEAD ignores ranges", method: "HEAD", headers: "Range: bytes=0-4\r\n", status: "200 OK", contains: []string{"Content-Length: 13\r\n"}},
+		{name: "If-Range date matches", headers: "Range: bytes=0-4\r\nIf-Range: " + modified + "\r\n", status: "206 Partial Content", body: "hello"},
+		{name: "If-Range date is stale", headers: "Range: bytes=0-4\r\nIf-Range: Thu, 29 Feb 2024 12:00:00 GMT\r\n", status: "200 OK", body: "hello, world\n"},
+		{name: "If-Range entity tag", headers: "Range: bytes=0-4\r\nIf-Range: \"abc\"\r\n", status: "200 OK", body: "hello, world\n"},
+		{name: "If-Range malformed", headers: "Range: bytes=0-4\r\nIf-Range: yesterday\r\n", status: "200 OK", body: "hello, world\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			out := serveRequest(t, fsrv.Handler(), method+" /hello.txt HTTP/1.1\r\nHost: localhost\r\n"+tc.headers+"\r\n")
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 "+tc.status+"\r\n"), out)
+			if tc.body != "" {
+				assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+tc.body), out)
+			}
+			for _, s := range tc.contains {
+				assert.Contains(t, out, s)
+			}
+		})
+	}
+}
+
+func TestMultipleRanges(t *testing.T) {
+	out := serveRequest(t, NewFileServer(testFiles).Handler(), "GET /hello.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=0-4, -6\r\n\r\n")
+	require.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"), out)
+
+	head, body, ok := strings.Cut(out, "\r\n\r\n")
+	require.True(t, ok)
+	var contentType, contentLength string
+	for _, line := range strings.Split(head, "\r\n")[1:] {
+		name, value, _ := strings.Cut(line, ": ")
+		switch name {
+		case "Content-Type":
+			contentType = value
+		case "Content-Length":
+			contentLength = value
+		}
+	}
+	assert.Equal(t, contentLength, strconv.Itoa(len(body)))
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	expected := []struct{ contentRange, data string }{
+		{"bytes 0-4/13", "hello"},
+		{"bytes 7-12/13", "world\n"},
+	}
+	for _, want := range expected {
+		part, err := reader.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		assert.Equal(t, want.contentRange, part.Header.Get("Content-Range"))
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, want.data, string(data))
+	}
+	_, err = reader.NextPart()
+	assert.ErrorIs(t, err, io.EOF)
+}
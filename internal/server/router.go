@@ -0,0 +1,210 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+)
+
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	segmentParam
+	segmentWildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	handler  Handler
+}
+
+// Router dispatches requests by method and path pattern. Patterns are
+// slash-separated and may contain parameters ("/users/{id}") and a trailing
+// wildcard ("/static/*"). Matched values are available through
+// req.PathValue; the wildcard is stored under "*".
+//
+// When several patterns match, the most specific wins: at the first segment
+// where they differ, a literal beats a parameter, which beats a wildcard.
+//
+// A GET route also answers HEAD unless a HEAD route is registered for the
+// same pattern; the server leaves out the body.
+type Router struct {
+	routes []route
+
+	// NotFound answers requests no pattern matches. Defaults to a plain 404.
+	NotFound Handler
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern %q must start with /", pattern)
+	}
+
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, 0, len(parts))
+	for i, part := range parts {
+		switch {
+		case part == "*":
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("pattern %q: wildcard must be the last segment", pattern)
+			}
+			segments = append(segments, segment{kind: segmentWildcard, value: "*"})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			if name == "" {
+				return nil, fmt.Errorf("pattern %q: empty parameter name", pattern)
+			}
+			segments = append(segments, segment{kind: segmentParam, value: name})
+		default:
+			segments = append(segments, segment{kind: segmentLiteral, value: part})
+		}
+	}
+	return segments, nil
+}
+
+// Handle registers handler for method and pattern. It panics on a malformed
+// pattern or a duplicate registration, both being programming errors.
+func (rt *Router) Handle(method string, pattern string, handler Handler) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	for _, r := range rt.routes {
+		if r.method == method && r.pattern == pattern {
+			panic(fmt.Sprintf("route %s %s registered twice", method, pattern))
+		}
+	}
+
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+		handler:  handler,
+	})
+}
+
+// match reports whether path fits the route and returns the captured values.
+func (r *route) match(parts []string) (map[string]string, bool) {
+	values := map[string]string{}
+	for i, seg := range r.segments {
+		if i >= len(parts) {
+			return nil, false
+		}
+		if seg.kind == segmentWildcard {
+			values["*"] = strings.Join(parts[i:], "/")
+			return values, true
+		}
+		switch seg.kind {
+		case segmentLiteral:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case segmentParam:
+			if parts[i] == "" {
+				return nil, false
+			}
+			values[seg.value] = parts[i]
+		}
+	}
+	if len(parts) != len(r.segments) {
+		return nil, false
+	}
+	return values, true
+}
+
+// moreSpecific reports whether a should be preferred over b.
+func moreSpecific(a, b *route) bool {
+	for i := 0; i < len(a.segments) && i < len(b.segments); i++ {
+		if a.segments[i].kind != b.segments[i].kind {
+			return a.segments[i].kind < b.segments[i].kind
+		}
+	}
+	return len(a.segments) > len(b.segments)
+}
+
+func (rt *Router) serve(w *response.Writer, req *request.Request) {
+	// Routes match the decoded, normalized path, so "/a/../b" and "/%62"
+	// both reach "/b".
+	path := req.Target.Path
+	if !strings.HasPrefix(path, "/") {
+		rt.notFound(w, req)
+		return
+	}
+	parts := strings.Split(path[1:], "/")
+
+	method := req.RequestLine.Method
+	var best *route
+	var bestValues map[string]string
+	allowed := map[string]bool{}
+	for i := range rt.routes {
+		r := &rt.routes[i]
+		values, ok := r.match(parts)
+		if !ok {
+			continue
+		}
+		allowed[r.method] = true
+		if r.method == "GET" {
+			allowed["HEAD"] = true
+		}
+		if r.method != method && !(method == "HEAD" && r.method == "GET") {
+			continue
+		}
+		// For equally specific patterns, a route for the method itself
+		// beats GET standing in for HEAD.
+		if best == nil || moreSpecific(r, best) ||
+			(!moreSpecific(best, r) && r.method == method && best.method != method) {
+			best = r
+			bestValues = values
+		}
+	}
+
+	if best == nil {
+		if len(allowed) == 0 {
+			rt.notFound(w, req)
+			return
+		}
+		methods := make([]string, 0, len(allowed))
+		for m := range allowed {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+
+		h := response.GetDefaultHeaders(0)
+		h.Set("Allow", strings.Join(methods, ", "))
+		writeStatus(w, req, response.StatusMethodNotAllowed, h)
+		return
+	}
+
+	for name, value := range bestValues {
+		req.SetPathValue(name, value)
+	}
+	best.handler(w, req)
+}
+
+func (rt *Router) notFound(w *response.Writer, req *request.Request) {
+	if rt.NotFound != nil {
+		rt.NotFound(w, req)
+		return
+	}
+	writeStatus(w, req, response.StatusNotFound, response.GetDefaultHeaders(0))
+}
+
+// Handler returns the router as a server.Handler.
+func (rt *Router) Handler() Handler {
+	return rt.serve
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+)
+
+// routeTo returns a handler that answers with name and the path values it got
+func routeTo(name string, params ...string) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		body := name
+		for _, p := range params {
+			body += " " + p + "=" + req.PathValue(p)
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func serveRequest(t *testing.T, handler Handler, raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parsing request: %v", err)
+	}
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	handler(w, req)
+	return out.String()
+}
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+	router.Handle("GET", "/", routeTo("root"))
+	router.Handle("GET", "/users", routeTo("users"))
+	router.Handle("POST", "/users", routeTo("create"))
+	router.Handle("GET", "/users/{id}", routeTo("user", "id"))
+	router.Handle("DELETE", "/users/{id}", routeTo("delete", "id"))
+	router.Handle("GET", "/users/me", routeTo("me"))
+	router.Handle("GET", "/users/{id}/posts/{post}", routeTo("post", "id", "post"))
+	router.Handle("GET", "/static/*", routeTo("static", "*"))
+	router.Handle("HEAD", "/static/*", routeTo("static head"))
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		status   string
+		body     string
+		contains string
+	}{
+		{name: "Root", method: "GET", target: "/", status: "200 OK", body: "root"},
+		{name: "Literal", method: "GET", target: "/users", status: "200 OK", body: "users"},
+		{name: "Method picks route", method: "POST", target: "/users", status: "200 OK", body: "create"},
+		{name: "Parameter", method: "GET", target: "/users/42", status: "200 OK", body: "user id=42"},
+		{name: "Literal beats parameter", method: "GET", target: "/users/me", status: "200 OK", body: "me"},
+		{name: "Two parameters", method: "GET", target: "/users/7/posts/hello", status: "200 OK", body: "post id=7 post=hello"},
+		{name: "Query is ignored", method: "GET", target: "/users/42?verbose=1", status: "200 OK", body: "user id=42"},
+		{name: "Decoded path", method: "GET", target: "/%75sers/%34%32", status: "200 OK", body: "user id=42"},
+		{name: "Normalized path", method: "GET", target: "/static/../users/./me", status: "200 OK", body: "me"},
+		{name: "Absolute form", method: "GET", target: "http://localhost/users/42", status: "200 OK", body: "user id=42"},
+		{name: "Wildcard", method: "GET", target: "/static/css/site.css", status: "200 OK", body: "static *=css/site.css"},
+		{name: "Wildcard empty rest", method: "GET", target: "/static/", status: "200 OK", body: "static *="},
+		{name: "Wildcard needs its prefix", method: "GET", target: "/static", status: "404 Not Found"},
+		{name: "Unknown path", method: "GET", target: "/nope", status: "404 Not Found"},
+		{name: "Parameter can't be empty", method: "GET", target: "/users//posts/x", status: "404 Not Found"},
+		{name: "Wrong method", method: "PUT", target: "/users/42", status: "405 Method Not Allowed", contains: "Allow: DELETE, GET, HEAD\r\n"},
+		{name: "HEAD uses GET route", method: "HEAD", target: "/users/42", status: "200 OK", body: "user id=42"},
+		{name: "HEAD route beats GET", method: "HEAD", target: "/static/x", status: "200 OK", body: "static head"},
+		{name: "HEAD next to other methods", method: "HEAD", target: "/users", status: "200 OK", body: "users"},
+		{name: "Only GET stands in for HEAD", method: "POST", target: "/", status: "405 Method Not Allowed", contains: "Allow: GET, HEAD\r\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := serveRequest(t, router.Handler(), tc.method+" "+tc.target+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 "+tc.status+"\r\n"), out)
+			if tc.body != "" {
+				assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+tc.body), out)
+			}
+			if tc.contains != "" {
+				assert.Contains(t, out, tc.contains)
+			}
+		})
+	}
+}
+
+func TestRouterNotFoundOverride(t *testing.T) {
+	router := NewRouter()
+	router.NotFound = routeTo("fallback")
+
+	out := serveRequest(t, router.Handler(), "GET /anything HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nfallback"), out)
+}
+
+func TestRouterBadPatterns(t *testing.T) {
+	router := NewRouter()
+	assert.Panics(t, func() { router.Handle("GET", "users", routeTo("x")) })
+	assert.Panics(t, func() { router.Handle("GET", "/static/*/more", routeTo("x")) })
+	assert.Panics(t, func() { router.Handle("GET", "/users/{}", routeTo("x")) })
+
+	router.Handle("GET", "/users", routeTo("x"))
+	assert.Panics(t, func() { router.Handle("GET", "/users", routeTo("y")) })
+}
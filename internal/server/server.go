@@ -1,12 +1,18 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/t3nna/http-from-tcp/internal/headers"
 	"github.com/t3nna/http-from-tcp/internal/request"
 	"github.com/t3nna/http-from-tcp/internal/response"
 	"io"
+	"log/slog"
 	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type HandlerError struct {
@@ -15,31 +21,325 @@ type HandlerError struct {
 }
 type Handler func(w *response.Writer, req *request.Request)
 
+type connState int
+
+const (
+	// connIdle connections are waiting for their next request to start and
+	// can be closed without losing work. A connection turns active with the
+	// first byte of a request, so one that is still arriving is let finish.
+	connIdle connState = iota
+	connActive
+)
+
+// shutdownPollInterval is how often Shutdown checks whether the remaining
+// connections have gone idle.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// errorWriteTimeout bounds writing a server-generated error response, such
+// as a 408, to a client that may not be reading.
+const errorWriteTimeout = time.Second
+
+// maxBodyDrain is how much of a request body the handler left unread the
+// server will discard to keep the connection alive. Larger leftovers close
+// the connection instead.
+const maxBodyDrain = 256 << 10
+
 type Server struct {
-	closed  atomic.Bool
-	handler Handler
+	closed   atomic.Bool
+	handler  Handler
+	listener net.Listener
+	config   Config
+
+	mu    sync.Mutex
+	conns map[io.ReadWriteCloser]connState
+
+	// baseCtx is the parent of every request context and is cancelled
+	// when the server shuts down.
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
+}
+
+func newServer(config Config, handler Handler) *Server {
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	return &Server{
+		closed:     atomic.Bool{},
+		handler:    handler,
+		config:     config,
+		baseCtx:    baseCtx,
+		cancelBase: cancelBase,
+	}
+}
+
+func (s *Server) requestContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if s.config.RequestTimeout > 0 {
+		return context.WithTimeout(parent, s.config.RequestTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// deadliner is implemented by net.Conn. Connections without it (as in
+// tests) simply run without timeouts.
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+func setReadDeadline(conn io.ReadWriteCloser, t time.Time) {
+	if c, ok := conn.(deadliner); ok {
+		c.SetReadDeadline(t)
+	}
+}
+
+func setWriteDeadline(conn io.ReadWriteCloser, t time.Time) {
+	if c, ok := conn.(deadliner); ok {
+		c.SetWriteDeadline(t)
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
+func (s *Server) setConnState(conn io.ReadWriteCloser, state connState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = map[io.ReadWriteCloser]connState{}
+	}
+	s.conns[conn] = state
+}
+
+func (s *Server) forgetConn(conn io.ReadWriteCloser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// closeIdleConns closes every idle connection and reports whether no
+// connections are left at all.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, state := range s.conns {
+		if state == connIdle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) closeAllConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+// wantsClose reports whether the connection can't be reused after req.
+// HTTP/1.1 connections persist unless the client says close; HTTP/1.0 ones
+// only if it opts in with keep-alive. An HTTP/1.0 request with
+// Transfer-Encoding, which that version doesn't have, may have been framed
+// differently by whatever relayed it, so its connection is always closed
+// (RFC 9112, 6.1).
+func wantsClose(req *request.Request) bool {
+	if req.Headers.HasToken("connection", "close") {
+		return true
+	}
+	if req.RequestLine.HttpVersion != "1.0" {
+		return false
+	}
+	if _, ok := req.Headers.Get("transfer-encoding"); ok {
+		return true
+	}
+	return !req.Headers.HasToken("connection", "keep-alive")
+}
+
+// runConnections serves requests off conn one at a time. A pipelining
+// client may send several requests up front; they stay buffered in the
+// request reader and are answered strictly in the order they arrived.
 func runConnections(s *Server, conn io.ReadWriteCloser) {
 	defer conn.Close()
+	defer s.forgetConn(conn)
+
+	connCtx, cancelConn := context.WithCancel(s.baseCtx)
+	defer cancelConn()
+
+	cr := newConnReader(conn)
+	reader := request.NewReader(cr)
+	defer reader.Release()
+	reader.Limits = s.config.Limits
+	var started time.Time
+
+	logger := s.logger()
+	addr := remoteAddr(conn)
+	if addr != "" {
+		logger = logger.With("remote_addr", addr)
+	}
+	if logger.Enabled(connCtx, slog.LevelDebug) {
+		reader.Debug = debugWriter{logger}
+	}
+
+	for served := 1; ; served++ {
+		s.setConnState(conn, connIdle)
+		if s.closed.Load() {
+			return
+		}
+
+		// Wait up to IdleTimeout for the next request to start, then give
+		// it ReadHeaderTimeout to send its headers and ReadTimeout overall.
+		started = time.Time{}
+		setReadDeadline(conn, deadline(time.Now(), s.config.IdleTimeout))
+		cr.notifyOnData(func() {
+			started = time.Now()
+			s.setConnState(conn, connActive)
+			setReadDeadline(conn, s.config.headerDeadline(started))
+		})
+
+		req, err := reader.ReadRequest()
+		s.setConnState(conn, connActive)
+
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if isTimeout(err) {
+			if !started.IsZero() {
+				// The client started a request but was too slow to
+				// finish it.
+				logger.Debug("request timed out", "error", err)
+				s.writeError(conn, response.StatusRequestTimeout, nil)
+			}
+			return
+		}
+		if err != nil {
+			status := statusForError(err)
+			logger.Debug("bad request", "error", err, "status", int(status))
+			s.writeError(conn, status, err)
+			return
+		}
+		req.RemoteAddr = addr
+
+		// The body is read while the handler runs, still bounded by
+		// ReadTimeout from the start of the request.
+		if started.IsZero() {
+			started = time.Now()
+		}
+		setReadDeadline(conn, deadline(started, s.config.ReadTimeout))
+		setWriteDeadline(conn, deadline(time.Now(), s.config.WriteTimeout))
+		responseWriter := response.NewWriter(conn)
+		if req.RequestLine.HttpVersion == "1.0" {
+			responseWriter.SetVersion(response.Version10)
+		}
+		if req.RequestLine.Method == "HEAD" {
+			responseWriter.OmitBody()
+		}
+
+		if wantsClose(req) || (s.config.MaxRequestsPerConn > 0 && served >= s.config.MaxRequestsPerConn) {
+			responseWriter.CloseConnection()
+		}
+		// Shutdown may begin while the handler runs; check again as late
+		// as possible so the client learns the connection is going away.
+		responseWriter.BeforeHeaders(func(response.StatusCode, *headers.Headers) {
+			if s.closed.Load() {
+				responseWriter.CloseConnection()
+			}
+		})
 
-	responseWriter := response.NewWriter(conn)
+		body := req.Body
+		ctx, cancel := s.requestContext(connCtx)
+		ctx = withLogger(ctx, logger.With("method", req.RequestLine.Method, "target", req.RequestLine.RequestTarget))
+		req = req.WithContext(ctx)
+		if body == request.NoBody {
+			// Only watch for a disconnect when the handler isn't going to
+			// read from the connection itself.
+			cr.startBackgroundRead(cancel)
+		}
 
-	req, err := request.RequestFromReader(conn)
+		ok := s.serve(responseWriter, req)
+		cr.abortPendingRead()
+		cancel()
+		if !ok {
+			return
+		}
 
+		if responseWriter.ShouldClose() || !drainBody(body) {
+			return
+		}
+	}
+}
+
+// drainBody discards what the handler left of body so the next request can
+// be read. It reports false if the body was too large to be worth reading or
+// ended in an error, in which case the connection must be closed.
+func drainBody(body io.ReadCloser) bool {
+	n, err := io.CopyN(io.Discard, body, maxBodyDrain+1)
+	if n > maxBodyDrain || !errors.Is(err, io.EOF) {
+		return false
+	}
+	return body.Close() == nil
+}
+
+// statusForError picks the response to a request that failed to parse.
+func statusForError(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ERROR_REQUEST_LINE_TOO_LONG):
+		return response.StatusURITooLong
+	case errors.Is(err, request.ERROR_HEADERS_TOO_LARGE), errors.Is(err, request.ERROR_TOO_MANY_HEADERS):
+		return response.StatusRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ERROR_BODY_TOO_LARGE):
+		return response.StatusContentTooLarge
+	case errors.Is(err, request.ERROR_UNSUPPORTED_HPPT_VERSION):
+		return response.StatusHTTPVersionNotSupported
+	}
+	return response.StatusBadRequest
+}
+
+// writeError answers a request that couldn't be read and closes the
+// connection. The body names what was wrong with the request, if err says.
+func (s *Server) writeError(conn io.ReadWriteCloser, statusCode response.StatusCode, err error) {
+	setWriteDeadline(conn, time.Now().Add(errorWriteTimeout))
+	var body []byte
 	if err != nil {
-		responseWriter.WriteStatusLine(response.StatusBarRequest)
-		responseWriter.WriteHeaders(response.GetDefaultHeaders(0))
-		return
+		body = fmt.Appendf(nil, "%d %s: %v\n", statusCode, response.StatusText(statusCode), err)
 	}
-	s.handler(responseWriter, req)
+	w := response.NewWriter(conn)
+	w.CloseConnection()
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// serve runs the handler and completes whatever response it left open. It
+// reports false when the handler panicked: a 500 is sent if nothing was
+// written yet, otherwise the client only sees the connection drop.
+func (s *Server) serve(w *response.Writer, req *request.Request) (ok bool) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			LoggerFromContext(req.Context()).Error("panic serving request", "panic", rec)
+			if !w.Written() {
+				w.CloseConnection()
+				w.WriteStatusLine(response.StatusInternalServerError)
+				w.WriteHeaders(response.GetDefaultHeaders(0))
+			}
+			ok = false
+		}
+	}()
 
+	s.handler(w, req)
+	w.Finish()
+	return true
 }
 
 func runServer(s *Server, listener net.Listener) {
 	for {
 		conn, err := listener.Accept()
 		if s.closed.Load() {
+			if conn != nil {
+				conn.Close()
+			}
 			return
 		}
 
@@ -47,31 +347,72 @@ func runServer(s *Server, listener net.Listener) {
 			return
 		}
 
+		// Track the connection before handing it off so Shutdown can't
+		// miss it.
+		s.setConnState(conn, connIdle)
 		go runConnections(s, conn)
 
 	}
 
 }
 
+// Serve listens on port and serves connections with DefaultConfig.
 func Serve(port uint16, handler Handler) (*Server, error) {
+	return ServeConfig(port, DefaultConfig(), handler)
+}
+
+// ServeConfig is Serve with explicit connection settings.
+func ServeConfig(port uint16, config Config, handler Handler) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
 	}
 
-	s := &Server{
-		closed:  atomic.Bool{},
-		handler: handler,
-	}
+	s := newServer(config, handler)
+	s.listener = listener
 	go runServer(s, listener)
 
 	return s, nil
 }
 
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops the listener and closes every connection right away,
+// including ones with a handler still running.
 func (s *Server) Close() error {
+	s.closed.Store(true)
+	s.cancelBase()
+	err := s.listener.Close()
+	s.closeAllConns()
+	return err
+}
 
-	s.closed.Store(false)
-	return nil
+// Shutdown stops accepting connections, closes idle keep-alive connections
+// and waits for in-flight requests to finish; their responses go out with
+// "Connection: close". Request contexts are cancelled right away so that
+// long-running handlers can wrap up early. If ctx ends first, the remaining
+// connections are closed forcibly and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closed.Store(true)
+	s.cancelBase()
+	err := s.listener.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.closeAllConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
 }
 
 func (s *Server) listen() {
@@ -0,0 +1,748 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/t3nna/http-from-tcp/internal/headers"
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+// fakeConn feeds the server from a chunkReader and records everything it writes
+type fakeConn struct {
+	*chunkReader
+	out    bytes.Buffer
+	closed bool
+}
+
+func (c *fakeConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newFakeConn(data string, chunkSize int) *fakeConn {
+	return &fakeConn{chunkReader: &chunkReader{data: data, numBytesPerRead: chunkSize}}
+}
+
+func newTestServer(handler Handler) *Server {
+	return newServer(DefaultConfig(), handler)
+}
+
+// echoTarget answers every request with its own request target as body
+func echoTarget(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.RequestTarget)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestBodylessResponsesKeepAlive(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "HEAD" {
+			w.WriteStatusLine(response.StatusOK)
+		} else {
+			w.WriteStatusLine(response.StatusNoContent)
+		}
+		w.WriteHeaders(headers.NewHeaders())
+	}
+	conn := newFakeConn("DELETE /one HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"HEAD /two HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"DELETE /three HTTP/1.1\r\nHost: localhost\r\n\r\n", 1024)
+	runConnections(newTestServer(handler), conn)
+
+	out := conn.out.String()
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\nHTTP/1.1 200 OK\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n", out)
+}
+
+func TestHeadErrorPageKeepsStreamInSync(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "HEAD" {
+			writeStatus(w, req, response.StatusNotFound, response.GetDefaultHeaders(0))
+			return
+		}
+		echoTarget(w, req)
+	}
+	conn := newFakeConn("HEAD /missing HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n", 1024)
+	runConnections(newTestServer(handler), conn)
+
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\nContent-Length: 14\r\nContent-Type: text/plain\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\n/next", conn.out.String())
+}
+
+func TestPipelinedResponsesInOrder(t *testing.T) {
+	pipelined := "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /two HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc" +
+		"GET /three HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	for _, chunkSize := range []int{1, 5, len(pipelined)} {
+		t.Run(fmt.Sprintf("chunk_size_%d", chunkSize), func(t *testing.T) {
+			conn := newFakeConn(pipelined, chunkSize)
+			runConnections(newTestServer(echoTarget), conn)
+
+			out := conn.out.String()
+			assert.Equal(t, 3, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+			one := strings.Index(out, "\r\n\r\n/one")
+			two := strings.Index(out, "\r\n\r\n/two")
+			three := strings.Index(out, "\r\n\r\n/three")
+			require.True(t, one >= 0 && two >= 0 && three >= 0, out)
+			assert.Less(t, one, two)
+			assert.Less(t, two, three)
+			assert.NotContains(t, out, "Connection: close")
+			assert.True(t, conn.closed)
+		})
+	}
+}
+
+func TestConnectionCloseStopsPipeline(t *testing.T) {
+	pipelined := "GET /one HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n" +
+		"GET /two HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	conn := newFakeConn(pipelined, len(pipelined))
+	runConnections(newTestServer(echoTarget), conn)
+
+	out := conn.out.String()
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.NotContains(t, out, "/two")
+}
+
+func TestMaxRequestsPerConn(t *testing.T) {
+	pipelined := strings.Repeat("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", 3)
+
+	s := newTestServer(echoTarget)
+	s.config.MaxRequestsPerConn = 2
+	conn := newFakeConn(pipelined, len(pipelined))
+	runConnections(s, conn)
+
+	out := conn.out.String()
+	assert.Equal(t, 2, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, 1, strings.Count(out, "Connection: close\r\n"))
+}
+
+func TestHandlerPanics(t *testing.T) {
+	// Test: Panic before anything was written
+	conn := newFakeConn("GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n", 1024)
+	runConnections(newTestServer(func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	}), conn)
+
+	out := conn.out.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"), out)
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1"))
+	assert.True(t, conn.closed)
+
+	// Test: Panic after the status line went out
+	conn = newFakeConn("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", 1024)
+	runConnections(newTestServer(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		panic("boom")
+	}), conn)
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", conn.out.String())
+}
+
+func TestImplicitResponse(t *testing.T) {
+	conn := newFakeConn("GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n", 1024)
+	runConnections(newTestServer(func(w *response.Writer, req *request.Request) {}), conn)
+
+	out := conn.out.String()
+	assert.Equal(t, 2, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, 2, strings.Count(out, "Content-Length: 0\r\n"))
+}
+
+func TestShutdownDrainsActiveRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		echoTarget(w, req)
+	})
+	require.NoError(t, err)
+
+	// An idle keep-alive connection that already got its response
+	idle, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+	_, err = idle.Write([]byte("GET /idle HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	buf := make([]byte, 1024)
+	_, err = idle.Read(buf)
+	require.NoError(t, err)
+
+	busy, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer busy.Close()
+	_, err = busy.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	done := make(chan error)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+
+	// The idle connection is closed right away
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = idle.Read(buf)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// New connections are refused
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned while a handler was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	out, err := io.ReadAll(busy)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, string(out), "Connection: close\r\n")
+	assert.NoError(t, <-done)
+}
+
+func TestShutdownWaitsForHalfSentRequest(t *testing.T) {
+	s, err := Serve(0, echoTarget)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /half HTTP/1.1\r\nHo"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, state := range s.conns {
+			if state == connActive {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+
+	done := make(chan error)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned while a request was arriving")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The rest of the request still gets its answer
+	_, err = conn.Write([]byte("st: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"), string(out))
+	assert.Contains(t, string(out), "Connection: close\r\n")
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\n/half"), string(out))
+	assert.NoError(t, <-done)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+	// The stuck connection was closed forcibly
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadAll(conn)
+	assert.NoError(t, err)
+}
+
+// awaitContext serves a request whose handler blocks until its context ends
+// and reports the context's error
+func awaitContext(t *testing.T, s *Server) (chan error, net.Conn) {
+	t.Helper()
+	ctxErr := make(chan error, 1)
+	s.handler = func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		ctxErr <- req.Context().Err()
+	}
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	return ctxErr, conn
+}
+
+func TestRequestContext(t *testing.T) {
+	t.Run("Cancelled when the client disconnects", func(t *testing.T) {
+		s, err := Serve(0, nil)
+		require.NoError(t, err)
+		defer s.Close()
+
+		ctxErr, conn := awaitContext(t, s)
+		time.Sleep(20 * time.Millisecond)
+		conn.Close()
+
+		select {
+		case err := <-ctxErr:
+			assert.ErrorIs(t, err, context.Canceled)
+		case <-time.After(time.Second):
+			t.Fatal("context not cancelled after disconnect")
+		}
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		config := DefaultConfig()
+		config.RequestTimeout = 20 * time.Millisecond
+		s, err := ServeConfig(0, config, nil)
+		require.NoError(t, err)
+		defer s.Close()
+
+		ctxErr, conn := awaitContext(t, s)
+		defer conn.Close()
+
+		select {
+		case err := <-ctxErr:
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		case <-time.After(time.Second):
+			t.Fatal("context deadline not applied")
+		}
+	})
+
+	t.Run("Cancelled on shutdown", func(t *testing.T) {
+		s, err := Serve(0, nil)
+		require.NoError(t, err)
+
+		ctxErr, conn := awaitContext(t, s)
+		defer conn.Close()
+		time.Sleep(20 * time.Millisecond)
+		go s.Shutdown(context.Background())
+
+		select {
+		case err := <-ctxErr:
+			assert.ErrorIs(t, err, context.Canceled)
+		case <-time.After(time.Second):
+			t.Fatal("context not cancelled on shutdown")
+		}
+	})
+
+	t.Run("Pipelined request survives the background read", func(t *testing.T) {
+		s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+			time.Sleep(20 * time.Millisecond)
+			assert.NoError(t, req.Context().Err())
+			echoTarget(w, req)
+		})
+		require.NoError(t, err)
+		defer s.Close()
+
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+		_, err = conn.Write([]byte("GET /two HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+		require.NoError(t, err)
+
+		out, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 2, strings.Count(string(out), "HTTP/1.1 200 OK\r\n"))
+		assert.True(t, strings.HasSuffix(string(out), "/two"))
+	})
+}
+
+// slowConn sends its first fastBytes bytes at once and then trickles the
+// rest one byte per delay, like a slowloris client. Once the data runs out it
+// keeps the connection open without sending anything. Read deadlines behave
+// like on a net.Conn, including interrupting a blocked read.
+type slowConn struct {
+	data      string
+	fastBytes int
+	delay     time.Duration
+	pos       int
+
+	mu           sync.Mutex
+	out          bytes.Buffer
+	readDeadline time.Time
+}
+
+func (c *slowConn) deadlinePassed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.readDeadline.IsZero() && time.Now().After(c.readDeadline)
+}
+
+func (c *slowConn) Read(p []byte) (int, error) {
+	if c.pos < c.fastBytes {
+		n := copy(p, c.data[c.pos:min(c.fastBytes, len(c.data))])
+		c.pos += n
+		return n, nil
+	}
+
+	wait := time.Now().Add(c.delay)
+	for c.pos >= len(c.data) || time.Now().Before(wait) {
+		if c.deadlinePassed() {
+			return 0, os.ErrDeadlineExceeded
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	n := copy(p[:1], c.data[c.pos:])
+	c.pos += n
+	return n, nil
+}
+
+func (c *slowConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.out.Write(p)
+}
+
+func (c *slowConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+func (c *slowConn) Close() error                       { return nil }
+func (c *slowConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func TestRequestBodyStreaming(t *testing.T) {
+	t.Run("Handler runs before the body arrives", func(t *testing.T) {
+		client, srv := net.Pipe()
+		defer client.Close()
+		s := newTestServer(readBody)
+		go runConnections(s, srv)
+
+		_, err := client.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nConnection: close\r\n\r\n"))
+		require.NoError(t, err)
+		// The handler is now blocked reading the body, which the client only
+		// sends after the headers went through.
+		_, err = client.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		out, err := io.ReadAll(client)
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nhello"), string(out))
+	})
+
+	t.Run("Large unread body closes the connection", func(t *testing.T) {
+		body := strings.Repeat("x", maxBodyDrain+1)
+		conn := newFakeConn(fmt.Sprintf("POST /big HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n%s", len(body), body)+
+			"GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n", 4096)
+		s := newTestServer(echoTarget)
+		s.config.Limits.MaxBodyBytes = 0
+		runConnections(s, conn)
+
+		out := conn.out.String()
+		assert.Contains(t, out, "/big")
+		assert.NotContains(t, out, "/next")
+	})
+}
+
+// readBody answers with the request body, or 408 if the client was too slow
+// to send it.
+func readBody(w *response.Writer, req *request.Request) {
+	body, err := req.BodyBytes()
+	if isTimeout(err) {
+		w.CloseConnection()
+		w.WriteStatusLine(response.StatusRequestTimeout)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		return
+	}
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestTimeouts(t *testing.T) {
+	const head = "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n"
+	const body = "0123456789"
+
+	testCases := []struct {
+		name      string
+		config    Config
+		data      string
+		fastBytes int
+		delay     time.Duration
+		expected  string
+	}{
+		{
+			name:      "Slowloris headers",
+			config:    Config{ReadHeaderTimeout: 30 * time.Millisecond, IdleTimeout: time.Second},
+			data:      head + body,
+			fastBytes: 10,
+			delay:     5 * time.Millisecond,
+			expected:  "HTTP/1.1 408 Request Timeout\r\n",
+		},
+		{
+			name:      "Slow body",
+			config:    Config{ReadHeaderTimeout: time.Second, ReadTimeout: 30 * time.Millisecond},
+			data:      head + body,
+			fastBytes: len(head),
+			delay:     5 * time.Millisecond,
+			expected:  "HTTP/1.1 408 Request Timeout\r\n",
+		},
+		{
+			name:      "Body may outlast the header timeout",
+			config:    Config{ReadHeaderTimeout: 20 * time.Millisecond, ReadTimeout: time.Second, IdleTimeout: 20 * time.Millisecond},
+			data:      head + body,
+			fastBytes: len(head),
+			delay:     5 * time.Millisecond,
+			expected:  "HTTP/1.1 200 OK\r\n",
+		},
+		{
+			name:      "Client stalls mid-request",
+			config:    Config{ReadHeaderTimeout: 30 * time.Millisecond},
+			data:      "GET / HTTP/1.1\r\n",
+			fastBytes: 16,
+			expected:  "HTTP/1.1 408 Request Timeout\r\n",
+		},
+		{
+			name:     "Idle connection is closed quietly",
+			config:   Config{IdleTimeout: 20 * time.Millisecond, ReadHeaderTimeout: time.Second},
+			expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &slowConn{data: tc.data, fastBytes: tc.fastBytes, delay: tc.delay}
+			s := newServer(tc.config, readBody)
+
+			done := make(chan struct{})
+			go func() {
+				runConnections(s, conn)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("connection was not timed out")
+			}
+
+			out := conn.out.String()
+			if tc.expected == "" {
+				assert.Empty(t, out)
+				return
+			}
+			assert.True(t, strings.HasPrefix(out, tc.expected), out)
+		})
+	}
+}
+
+func TestRequestLimitResponses(t *testing.T) {
+	testCases := []struct {
+		name     string
+		request  string
+		expected string
+	}{
+		{
+			name:     "Long request line",
+			request:  "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			expected: "HTTP/1.1 414 URI Too Long\r\n",
+		},
+		{
+			name:     "Large headers",
+			request:  "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: " + strings.Repeat("c", 200) + "\r\n\r\n",
+			expected: "HTTP/1.1 431 Request Header Fields Too Large\r\n",
+		},
+		{
+			name:     "Large body",
+			request:  "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1000\r\n\r\n",
+			expected: "HTTP/1.1 413 Content Too Large\r\n",
+		},
+		{
+			name:     "Malformed request",
+			request:  "GET / HTTP/1.1\r\nHost localhost\r\n\r\n",
+			expected: "HTTP/1.1 400 Bad Request\r\n",
+		},
+	}
+
+	config := DefaultConfig()
+	config.Limits = request.Limits{
+		MaxRequestLineBytes: 64,
+		MaxHeaderBytes:      128,
+		MaxHeaderCount:      10,
+		MaxBodyBytes:        100,
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := newFakeConn(tc.request, 16)
+			runConnections(newServer(config, echoTarget), conn)
+			out := conn.out.String()
+			assert.True(t, strings.HasPrefix(out, tc.expected), out)
+			assert.Contains(t, out, "Connection: close\r\n")
+		})
+	}
+}
+
+func TestHTTP10(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		handler  Handler
+		expected []string
+	}{
+		{
+			name:     "Closes by default",
+			raw:      "GET /one HTTP/1.0\r\n\r\nGET /two HTTP/1.0\r\n\r\n",
+			handler:  echoTarget,
+			expected: []string{"HTTP/1.0 200 OK\r\nContent-Length: 4\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n/one"},
+		},
+		{
+			name:    "Keep-alive opt-in",
+			raw:     "GET /one HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET /two HTTP/1.0\r\n\r\n",
+			handler: echoTarget,
+			expected: []string{
+				"HTTP/1.0 200 OK\r\nContent-Length: 4\r\nContent-Type: text/plain\r\nConnection: keep-alive\r\n\r\n/one",
+				"HTTP/1.0 200 OK\r\nContent-Length: 4\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n/two",
+			},
+		},
+		{
+			name: "No chunked responses",
+			raw:  "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET /never HTTP/1.0\r\n\r\n",
+			handler: func(w *response.Writer, req *request.Request) {
+				h := headers.NewHeaders()
+				h.Set("Transfer-Encoding", "chunked")
+				w.WriteStatusLine(response.StatusOK)
+				w.WriteHeaders(h)
+				w.WriteChunkedBody([]byte("streamed"))
+			},
+			expected: []string{"HTTP/1.0 200 OK\r\nConnection: close\r\n\r\nstreamed"},
+		},
+		{
+			name:     "Chunked request closes",
+			raw:      "POST /one HTTP/1.0\r\nConnection: keep-alive\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\nGET /two HTTP/1.0\r\n\r\n",
+			handler:  echoTarget,
+			expected: []string{"HTTP/1.0 200 OK\r\nContent-Length: 4\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n/one"},
+		},
+		{
+			name:     "Later minor version is answered as 1.1",
+			raw:      "GET /one HTTP/1.2\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+			handler:  echoTarget,
+			expected: []string{"HTTP/1.1 200 OK\r\nContent-Length: 4\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n/one"},
+		},
+		{
+			name:     "Unknown version",
+			raw:      "GET / HTTP/2.0\r\n\r\n",
+			handler:  echoTarget,
+			expected: []string{"HTTP/1.1 505 HTTP Version Not Supported\r\n"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := newFakeConn(tc.raw, 1024)
+			runConnections(newTestServer(tc.handler), conn)
+
+			out := conn.out.String()
+			for _, expected := range tc.expected {
+				require.True(t, strings.HasPrefix(out, expected), out)
+				out = out[len(expected):]
+			}
+			if !strings.HasPrefix(tc.expected[0], "HTTP/1.1 505") {
+				assert.Empty(t, out)
+			}
+		})
+	}
+}
+
+func TestBadRequestNamesTheProblem(t *testing.T) {
+	conn := newFakeConn("GET / HTTP/1.1\r\nHost: localhost\r\nX-Long: first\r\n  second\r\n\r\n", 1024)
+	runConnections(newTestServer(echoTarget), conn)
+
+	out := conn.out.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n400 Bad Request: obsolete line folding in header\n"), out)
+}
+
+func TestServerLogging(t *testing.T) {
+	const raw = "GET /hello HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	handler := func(w *response.Writer, req *request.Request) {
+		LoggerFromContext(req.Context()).Info("handling")
+	}
+
+	t.Run("Debug level", func(t *testing.T) {
+		var buf bytes.Buffer
+		s := newTestServer(handler)
+		s.config.Logger = newTestLogger(&buf, slog.LevelDebug)
+		runConnections(s, newFakeConn(raw+"GET /\r\n\r\n", 1024))
+
+		out := buf.String()
+		assert.Contains(t, out, `level=DEBUG msg="request head" raw="GET /hello HTTP/1.1\r\nHost: localhost\r\n\r\n"`)
+		assert.Contains(t, out, "level=INFO msg=handling method=GET target=/hello\n")
+		assert.Contains(t, out, `level=DEBUG msg="bad request" error="malformed request-line" status=400`)
+	})
+
+	t.Run("Debug is off by default", func(t *testing.T) {
+		var buf bytes.Buffer
+		s := newTestServer(handler)
+		s.config.Logger = newTestLogger(&buf, slog.LevelInfo)
+		runConnections(s, newFakeConn(raw, 1024))
+
+		assert.Equal(t, "level=INFO msg=handling method=GET target=/hello\n", buf.String())
+	})
+}
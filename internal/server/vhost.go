@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/t3nna/http-from-tcp/internal/request"
+	"github.com/t3nna/http-from-tcp/internal/response"
+)
+
+// VirtualHosts dispatches requests by host name so several sites can share
+// one port. Names are matched case-insensitively and without the port. A
+// pattern of the form "*.example.com" matches every subdomain of example.com,
+// at any depth, but not example.com itself.
+//
+// An exact name beats a wildcard, and a longer wildcard beats a shorter one:
+// with "*.example.com" and "*.api.example.com" registered, "v1.api.example.com"
+// goes to the latter.
+type VirtualHosts struct {
+	hosts map[string]Handler
+	// wildcards is keyed by the suffix a host must have, ".example.com".
+	wildcards map[string]Handler
+
+	// Default answers requests for hosts nothing matches, including
+	// HTTP/1.0 requests without a Host. Defaults to a plain 421.
+	Default Handler
+}
+
+func NewVirtualHosts() *VirtualHosts {
+	return &VirtualHosts{
+		hosts:     map[string]Handler{},
+		wildcards: map[string]Handler{},
+	}
+}
+
+// Handle registers handler for the host name or wildcard pattern. It panics
+// on a malformed pattern or a duplicate registration, both being programming
+// errors.
+func (v *VirtualHosts) Handle(pattern string, handler Handler) {
+	name := hostName(pattern)
+	registered := v.hosts
+	if suffix, ok := strings.CutPrefix(name, "*"); ok {
+		name = suffix
+		registered = v.wildcards
+		if !strings.HasPrefix(name, ".") || len(name) < 2 {
+			panic(fmt.Sprintf("host pattern %q: wildcard must be followed by a domain", pattern))
+		}
+	}
+	if name == "" || strings.Contains(name, "*") {
+		panic(fmt.Sprintf("host pattern %q is malformed", pattern))
+	}
+	if _, ok := registered[name]; ok {
+		panic(fmt.Sprintf("host %s registered twice", pattern))
+	}
+	registered[name] = handler
+}
+
+// match returns the handler for host, or nil.
+func (v *VirtualHosts) match(host string) Handler {
+	name := hostName(host)
+	if name == "" {
+		return nil
+	}
+	if h, ok := v.hosts[name]; ok {
+		return h
+	}
+	// Try ever shorter suffixes so the longest wildcard wins.
+	for i := 0; i < len(name); i++ {
+		if name[i] != '.' {
+			continue
+		}
+		if h, ok := v.wildcards[name[i:]]; ok {
+			return h
+		}
+	}
+	return nil
+}
+
+func (v *VirtualHosts) serve(w *response.Writer, req *request.Request) {
+	if h := v.match(req.Host); h != nil {
+		h(w, req)
+		return
+	}
+	if v.Default != nil {
+		v.Default(w, req)
+		return
+	}
+	writeStatus(w, req, response.StatusMisdirectedRequest, response.GetDefaultHeaders(0))
+}
+
+// Handler returns the virtual hosts as a server.Handler.
+func (v *VirtualHosts) Handler() Handler {
+	return v.serve
+}
+
+// hostName reduces a host[:port] to the lowercased name, without the port,
+// the brackets of an IPv6 literal or the trailing dot of a fully qualified
+// name.
+func hostName(host string) string {
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
+	}
+	host = strings.TrimPrefix(host, "[")
+	host = strings.TrimSuffix(host, "]")
+	host = strings.TrimSuffix(host, ".")
+	return strings.ToLower(host)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	vhosts := NewVirtualHosts()
+	vhosts.Handle("example.com", routeTo("example"))
+	vhosts.Handle("www.example.com", routeTo("www"))
+	vhosts.Handle("*.example.com", routeTo("any-example"))
+	vhosts.Handle("*.api.example.com", routeTo("any-api"))
+	vhosts.Handle("Blog.Example.org", routeTo("blog"))
+	vhosts.Handle("[::1]", routeTo("loopback"))
+
+	testCases := []struct {
+		name    string
+		request string
+		status  string
+		body    string
+	}{
+		{name: "Exact", request: "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", status: "200 OK", body: "example"},
+		{name: "Port is ignored", request: "GET / HTTP/1.1\r\nHost: example.com:42069\r\n\r\n", status: "200 OK", body: "example"},
+		{name: "Case-insensitive", request: "GET / HTTP/1.1\r\nHost: BLOG.example.ORG\r\n\r\n", status: "200 OK", body: "blog"},
+		{name: "Trailing dot", request: "GET / HTTP/1.1\r\nHost: example.com.\r\n\r\n", status: "200 OK", body: "example"},
+		{name: "Exact beats wildcard", request: "GET / HTTP/1.1\r\nHost: www.example.com\r\n\r\n", status: "200 OK", body: "www"},
+		{name: "Wildcard", request: "GET / HTTP/1.1\r\nHost: shop.example.com\r\n\r\n", status: "200 OK", body: "any-example"},
+		{name: "Wildcard at depth", request: "GET / HTTP/1.1\r\nHost: a.b.example.com\r\n\r\n", status: "200 OK", body: "any-example"},
+		{name: "Longest wildcard wins", request: "GET / HTTP/1.1\r\nHost: v1.api.example.com\r\n\r\n", status: "200 OK", body: "any-api"},
+		{name: "IPv6 literal", request: "GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n", status: "200 OK", body: "loopback"},
+		{name: "Absolute form names the host", request: "GET http://www.example.com/ HTTP/1.1\r\nHost: other.test\r\n\r\n", status: "200 OK", body: "www"},
+		{name: "Unknown host", request: "GET / HTTP/1.1\r\nHost: example.net\r\n\r\n", status: "421 Misdirected Request"},
+		{name: "Wildcard needs a subdomain", request: "GET / HTTP/1.1\r\nHost: notexample.com\r\n\r\n", status: "421 Misdirected Request"},
+		{name: "HTTP/1.0 without Host", request: "GET / HTTP/1.0\r\n\r\n", status: "421 Misdirected Request"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := serveRequest(t, vhosts.Handler(), tc.request)
+			assert.Contains(t, out, " "+tc.status+"\r\n")
+			if tc.body != "" {
+				assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+tc.body), out)
+			}
+		})
+	}
+}
+
+func TestVirtualHostsDefault(t *testing.T) {
+	vhosts := NewVirtualHosts()
+	vhosts.Handle("example.com", routeTo("example"))
+	vhosts.Default = routeTo("fallback")
+
+	out := serveRequest(t, vhosts.Handler(), "GET / HTTP/1.1\r\nHost: example.net\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nfallback"), out)
+}
+
+func TestVirtualHostsBadPatterns(t *testing.T) {
+	vhosts := NewVirtualHosts()
+	assert.Panics(t, func() { vhosts.Handle("", routeTo("x")) })
+	assert.Panics(t, func() { vhosts.Handle("*", routeTo("x")) })
+	assert.Panics(t, func() { vhosts.Handle("*example.com", routeTo("x")) })
+	assert.Panics(t, func() { vhosts.Handle("a.*.example.com", routeTo("x")) })
+
+	vhosts.Handle("*.example.com", routeTo("x"))
+	assert.Panics(t, func() { vhosts.Handle("*.Example.com", routeTo("y")) })
+}